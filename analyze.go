@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// measured gains by path, for songs without ReplayGain tags
+var loudnessCache = struct {
+	sync.Mutex
+	entries map[string]loudnessEntry
+	once    sync.Once
+}{}
+
+// a measurement, valid while the file keeps its size and modification time
+type loudnessEntry struct {
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	Failed    bool      `json:"failed,omitempty"` // so it isn't decoded again after every scan
+	TrackGain float64   `json:"track_gain"`
+	TrackPeak float64   `json:"track_peak"`
+	AlbumGain *float64  `json:"album_gain,omitempty"`
+	AlbumPeak float64   `json:"album_peak,omitempty"`
+}
+
+func (e loudnessEntry) replayGain() replayGain {
+	if e.Failed {
+		return replayGain{unmeasurable: true}
+	}
+	rg := replayGain{trackGain: e.TrackGain, trackPeak: e.TrackPeak, hasTrack: true}
+	if e.AlbumGain != nil {
+		rg.albumGain, rg.albumPeak, rg.hasAlbum = *e.AlbumGain, e.AlbumPeak, true
+	}
+	return rg
+}
+
+func loudnessCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "podden", "loudness.json"), nil
+}
+
+// read the cache on first use, a missing or broken one is started over
+func loadLoudnessCache() {
+	loudnessCache.once.Do(func() {
+		loudnessCache.entries = make(map[string]loudnessEntry)
+		path, err := loudnessCachePath()
+		if err != nil {
+			return
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &loudnessCache.entries)
+		}
+	})
+}
+
+func saveLoudnessCache() error {
+	path, err := loudnessCachePath()
+	if err != nil {
+		return err
+	}
+	loudnessCache.Lock()
+	data, err := json.Marshal(loudnessCache.entries)
+	loudnessCache.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// the measured gain of a file, if it hasn't changed since
+func cachedGain(path string, info fs.FileInfo) (replayGain, bool) {
+	loadLoudnessCache()
+	loudnessCache.Lock()
+	defer loudnessCache.Unlock()
+	e, ok := loudnessCache.entries[path]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return replayGain{}, false
+	}
+	return e.replayGain(), true
+}
+
+func cacheGain(path string, rg replayGain) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	e := loudnessEntry{
+		Size:      info.Size(),
+		ModTime:   info.ModTime(),
+		Failed:    rg.unmeasurable,
+		TrackGain: rg.trackGain,
+		TrackPeak: rg.trackPeak,
+	}
+	if rg.hasAlbum {
+		e.AlbumGain, e.AlbumPeak = &rg.albumGain, rg.albumPeak
+	}
+	loadLoudnessCache()
+	loudnessCache.Lock()
+	loudnessCache.entries[path] = e
+	loudnessCache.Unlock()
+}
+
+// songs measured together: the tracks of an album, or a single song
+func analysisGroups(musics []music) [][]music {
+	var groups [][]music
+	inAlbum := make(map[string]bool)
+	for _, a := range groupAlbums(musics) {
+		groups = append(groups, a.tracks)
+		for _, t := range a.tracks {
+			inAlbum[t.path] = true
+		}
+	}
+	for _, m := range musics {
+		if !inAlbum[m.path] {
+			groups = append(groups, []music{m})
+		}
+	}
+	return groups
+}
+
+// whether a group has a song without a gain that might still be measured
+func needsAnalysis(group []music) bool {
+	for _, m := range group {
+		if !m.gain.hasTrack && !m.gain.unmeasurable {
+			return true
+		}
+	}
+	return false
+}
+
+// measure a group and cache the results. gains a song already has are kept
+// unless force is set, songs that can't be measured are cached as such.
+// album gain is only given to groups that are albums, and only when every
+// song could be measured
+func analyzeGroup(group []music, isAlbum, force bool, report func(m music, err error)) []music {
+	var measured []loudness
+	var gains []replayGain
+	var done []music
+	failed := false
+	for _, m := range group {
+		l, err := measureLoudness(m.path)
+		var rg replayGain
+		if err == nil {
+			var ok bool
+			if rg, ok = trackGain(l); !ok {
+				err = errors.New("only silence")
+			}
+		}
+		report(m, err)
+		if err != nil {
+			failed = true
+			cacheGain(m.path, replayGain{unmeasurable: true})
+			continue
+		}
+		measured = append(measured, l)
+		gains = append(gains, rg)
+		done = append(done, m)
+	}
+
+	album, ok := albumGain(measured)
+	for i, m := range done {
+		rg := gains[i]
+		if isAlbum && !failed && ok {
+			rg.albumGain, rg.albumPeak, rg.hasAlbum = album.albumGain, album.albumPeak, true
+		}
+		cacheGain(m.path, rg)
+		if force {
+			m.gain = rg
+		} else {
+			m.gain = m.gain.fill(rg)
+		}
+		done[i] = m
+	}
+	return done
+}
+
+// podden analyze: measure the loudness of the library and cache it, with
+// -write also saving it as ReplayGain tags
+func runAnalyze(args []string) int {
+	flags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	write := flags.Bool("write", false, "write REPLAYGAIN tags to the files (mp3 and flac)")
+	force := flags.Bool("force", false, "measure songs that already have a gain again")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: podden [-m dir] analyze [-write] [-force]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if _, err := loadConfig(); err != nil {
+		fmt.Println("Error loading config:")
+		fmt.Println(err)
+		return 1
+	}
+
+	var musics []music
+	failed := 0
+	walkLibrary(context.Background(), func(path string) {
+		m, err := readMusic(path)
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			failed++
+			return
+		}
+		musics = append(musics, m)
+	}, func(path string, err error) {
+		fmt.Printf("%s: %v\n", path, err)
+		failed++
+	})
+
+	measured := 0
+	for _, group := range analysisGroups(musics) {
+		if !*force && !needsAnalysis(group) {
+			continue
+		}
+		isAlbum := group[0].album != ""
+		before := make(map[string]replayGain)
+		for _, m := range group {
+			before[m.path] = m.gain
+		}
+		done := analyzeGroup(group, isAlbum, *force, func(m music, err error) {
+			if err != nil {
+				fmt.Printf("%s: %v\n", m.path, err)
+				failed++
+			}
+		})
+
+		for _, m := range done {
+			measured++
+			fmt.Printf("%s: %s\n", m.path, gainText(m.gain))
+			// without -force only what a song lacked is written
+			old := before[m.path]
+			track := *force || !old.hasTrack
+			if *write && (track || !old.hasAlbum && m.gain.hasAlbum) {
+				if err := writeReplayGainTags(m.path, m.gain, track); err != nil {
+					fmt.Printf("%s: can't write tags: %v\n", m.path, err)
+					failed++
+				}
+			}
+		}
+		if err := saveLoudnessCache(); err != nil {
+			fmt.Println("can't save loudness cache:", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("measured %d songs, %d problems\n", measured, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func gainText(rg replayGain) string {
+	text := fmt.Sprintf("track %+.2f dB, peak %.6f", rg.trackGain, rg.trackPeak)
+	if rg.hasAlbum {
+		text += fmt.Sprintf(", album %+.2f dB, peak %.6f", rg.albumGain, rg.albumPeak)
+	}
+	return text
+}
+
+type analyzedMsg struct{ count int }
+
+// one background analysis at a time, a rescan finishing during a run would
+// otherwise measure and cache the same songs twice at once
+var analyzeMu sync.Mutex
+
+// measure songs without a gain once the scan is done, one album at a time so
+// normalisation starts working on the first ones quickly
+func analyzeLibrary() tea.Msg {
+	analyzeMu.Lock()
+	defer analyzeMu.Unlock()
+
+	count := 0
+	for _, group := range analysisGroups(lib.all()) {
+		if !needsAnalysis(group) {
+			continue
+		}
+		done := analyzeGroup(group, group[0].album != "", false, func(music, error) {})
+		for _, m := range done {
+			lib.setGain(m.path, m.gain)
+		}
+		count += len(done)
+		saveLoudnessCache()
+	}
+	return analyzedMsg{count}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type genre struct {
+	key    string // normalized name, so "Hip Hop" and "hip hop" are one genre
+	name   string
+	tracks []music
+}
+
+// the tracks of ten years, start is 0 for tracks without a year
+type decade struct {
+	start  int
+	tracks []music
+}
+
+type (
+	genresMsg  struct{ genres []genre }
+	decadesMsg struct{ decades []decade }
+)
+
+func (g genre) Title() string       { return g.name }
+func (g genre) Description() string { return trackCount(len(g.tracks)) }
+func (g genre) FilterValue() string { return g.name }
+
+func (d decade) Title() string {
+	if d.start == 0 {
+		return "Unknown Year"
+	}
+	return fmt.Sprintf("%ds", d.start)
+}
+func (d decade) Description() string { return trackCount(len(d.tracks)) }
+func (d decade) FilterValue() string { return d.Title() }
+
+func (d decade) key() string { return strconv.Itoa(d.start) }
+
+func fetchGenres() tea.Msg {
+	return genresMsg{groupGenres(lib.all())}
+}
+
+func fetchDecades() tea.Msg {
+	return decadesMsg{groupDecades(lib.all())}
+}
+
+func genreOf(m music) string {
+	if g := strings.TrimSpace(m.genre); g != "" {
+		return g
+	}
+	return "Unknown Genre"
+}
+
+func decadeOf(m music) int {
+	return m.year / 10 * 10
+}
+
+// group tracks by genre, sorted by name
+func groupGenres(musics []music) []genre {
+	genresMap := make(map[string]genre)
+	for _, m := range musics {
+		name := genreOf(m)
+		key := normalizeName(name)
+
+		g, ok := genresMap[key]
+		if !ok {
+			g = genre{key: key, name: name}
+		}
+		g.tracks = append(g.tracks, m)
+		genresMap[key] = g
+	}
+
+	genres := make([]genre, 0, len(genresMap))
+	for _, g := range genresMap {
+		genres = append(genres, g)
+	}
+	slices.SortFunc(genres, func(a, b genre) int {
+		return naturalCompare(a.name, b.name)
+	})
+	return genres
+}
+
+// group tracks by decade, oldest first and unknown years last
+func groupDecades(musics []music) []decade {
+	decadesMap := make(map[int]decade)
+	for _, m := range musics {
+		start := decadeOf(m)
+		d := decadesMap[start]
+		d.start = start
+		d.tracks = append(d.tracks, m)
+		decadesMap[start] = d
+	}
+
+	decades := make([]decade, 0, len(decadesMap))
+	for _, d := range decadesMap {
+		decades = append(decades, d)
+	}
+	slices.SortFunc(decades, func(a, b decade) int {
+		switch {
+		case a.start == 0:
+			return 1
+		case b.start == 0:
+			return -1
+		}
+		return a.start - b.start
+	})
+	return decades
+}
+
+func byGenre(key string) func(music) bool {
+	return func(m music) bool { return normalizeName(genreOf(m)) == key }
+}
+
+func byDecade(key string) func(music) bool {
+	start, _ := strconv.Atoi(key)
+	return func(m music) bool { return decadeOf(m) == start }
+}
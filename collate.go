@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// compare strings the way people expect, ignoring case and ordering runs of
+// digits by value so "track 2" comes before "track 10"
+func naturalCompare(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if isDigit(ra) && isDigit(rb) {
+			na, restA := digitRun(a)
+			nb, restB := digitRun(b)
+			if c := compareNumbers(na, nb); c != 0 {
+				return c
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[sizeA:], b[sizeB:]
+	}
+	return len(a) - len(b)
+}
+
+// the leading digits of s and what follows them
+func digitRun(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !isDigit(r) })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// compare two digit strings by value, without overflowing on long runs
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
@@ -1,32 +1,44 @@
 package main
 
 import (
-	"io"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v3"
+	"sync/atomic"
 )
 
 type config struct {
-	HeadingForeground             string `yaml:"heading_foreground"`
-	HeadingBackground             string `yaml:"heading_background"`
-	BorderForeground              string `yaml:"border_foreground"`
-	NormalTitleForeground         string `yaml:"normal_title_foreground"`
-	NormalDescForeground          string `yaml:"normal_desc_foreground"`
-	SelectedTitleBorderForeground string `yaml:"selected_title_border_foreground"`
-	SelectedTitleForeground       string `yaml:"selected_title_foreground"`
-	SelectedDescForeground        string `yaml:"selected_desc_foreground"`
-	DimmedTitleForeground         string `yaml:"dimmed_title_foreground"`
-	DimmedDescForeground          string `yaml:"dimmed_desc_foreground"`
-	ArtistForeground              string `yaml:"artist_foreground"`
-	TimeForeground                string `yaml:"time_foreground"`
-	LyricsForeground              string `yaml:"lyrics_foreground"`
-	ShowHelp                      bool   `yaml:"show_help"`
+	Theme string           `yaml:"theme"`
+	theme `yaml:",inline"` // colours set here override the theme
+
+	ShowHelp   bool             `yaml:"show_help"`
+	Layout     string           `yaml:"layout"`
+	Keys       keysConfig       `yaml:"keys"`
+	Library    libraryConfig    `yaml:"library"`
+	Scrobble   scrobbleConfig   `yaml:"scrobble"`
+	ReplayGain replayGainConfig `yaml:"replaygain"`
+	EQ         eqConfig         `yaml:"eq"`
+}
+
+// the config in use. a reload swaps in a new one instead of changing it, so
+// the scanner and other background work always see a whole config
+var currentConfig atomic.Pointer[config]
+
+func init() {
+	currentConfig.Store(&config{})
+}
+
+func cfg() *config {
+	return currentConfig.Load()
 }
 
-var defaultConfigYaml = `# heading styles (album, songs, artists)
+var defaultConfigYaml = `# a built-in theme (default, ipod, ipod-black, nord, gruvbox, dracula)
+# or the name of a file in the themes directory next to this config
+theme: default
+
+# the colours below override the theme, leave them empty to use it as is
+
+# heading styles (album, songs, artists)
 heading_background: ""
 heading_foreground: ""
 border_foreground: ""
@@ -48,33 +60,120 @@ time_foreground: ""
 lyrics_foreground: ""
 
 show_help: true
+
+# compact (ipod sized), full (fills the terminal) or wide (library, playing and lyrics side by side)
+layout: full
+
+# remap any action to a key, a list of keys or a sequence like "g g"
+# (up, down, play, pause, next, prev, enqueue, forward, rewind, increase,
+# decrease, mute, eq, eq_up, eq_down, rate, favourite, albums, songs,
+# artists, genres, years, playlists, stats, playing, sort, search,
+# save_search, back, forward_page, help, quit, stop_scan, problems, retry,
+# layout). rate takes the keys for 0 to
+# 5 stars in order. an empty list unbinds
+keys:
+  # next: [n, ctrl+n]
+  # albums: g a
+
+# where your music is, -m overrides dirs for a single run
+library:
+  dirs:
+    - ~/Music
+  # glob patterns of files and folders to skip
+  exclude:
+    # - "*/Podcasts/*"
+    # - "*.part"
+  # also scan folders that are symlinks
+  follow_symlinks: false
+  # how many folders deep to scan, 0 for no limit
+  max_depth: 0
+  # also save ratings in the files themselves, as POPM frames in mp3s and
+  # FMPS_RATING in flacs, so other players see them
+  write_ratings: false
+
+# even out loudness with ReplayGain or R128 tags
+replaygain:
+  # track (every song as loud as the next), album (keeps the loudness steps
+  # within an album) or off
+  mode: track
+  # dB added on top of the tagged gain
+  preamp: 0
+  # turn the gain down where it would push a song's peak past full scale
+  prevent_clipping: true
+  # measure the loudness of songs without tags in the background after the
+  # scan, like podden analyze does
+  analyze: false
+
+# the equalizer, opened with = and adjusted with [ and ]
+eq:
+  # centre frequencies of the bands in Hz
+  bands: [31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000]
+  # how wide each band is, higher is narrower
+  q: 1.41
+  # your own presets next to flat, bass boost, vocal and loudness, with a
+  # gain in dB from -12 to 12 for every band
+  presets:
+    # mine: [4, 3, 1, 0, -1, 0, 1, 2, 3, 3]
+
+# send what you listen to once half a song or four minutes of it has played.
+# listens are queued in scrobbles.jsonl while offline and sent later
+scrobble:
+  listenbrainz:
+    # from https://listenbrainz.org/settings/
+    token: ""
+    url: https://api.listenbrainz.org
+  lastfm:
+    api_key: ""
+    secret: ""
+    session_key: ""
+    # or any Last.fm compatible api, like https://libre.fm/2.0/
+    url: https://ws.audioscrobbler.com/2.0/
 `
 
-func loadConfig(cfg *config) {
-	configDir, err := os.UserConfigDir()
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
+	return filepath.Join(dir, "podden"), nil
+}
 
-	configPath := filepath.Join(configDir, "podden", "config.yml")
+// load config.yml as the config in use, creating it on the first run. the returned
+// warnings are problems podden can live with, like unknown keys
+func loadConfig() ([]string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := filepath.Join(dir, "config.yml")
 
 	// check if ~/.config/podden exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		os.MkdirAll(filepath.Dir(configPath), 0755)
 		err = os.WriteFile(configPath, []byte(defaultConfigYaml), 0644)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 	}
 
-	f, err := os.Open(configPath)
+	data, err := os.ReadFile(configPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	decoder := yaml.NewDecoder(f)
-	err = decoder.Decode(cfg)
-	if err != nil && err != io.EOF {
-		log.Fatal(err)
+	// only replace the config once the whole file is known to be good
+	var loaded config
+	warnings, err := decodeYaml(data, "config.yml", &loaded)
+	if err != nil {
+		return warnings, err
 	}
+	km, err := buildKeyMap(loaded.Keys)
+	if err != nil {
+		return warnings, fmt.Errorf("config.yml: %w", err)
+	}
+
+	currentConfig.Store(&loaded)
+	keys = km
+	return warnings, nil
 }
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// what the stream headers tell about an audio file
+type streamInfo struct {
+	duration time.Duration // 0 when unknown
+	size     int64         // bytes of audio, without tags and cover art
+}
+
+// length and audio size of a file, read from the stream headers
+func probeStream(path string, fileType tag.FileType) (streamInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	defer f.Close()
+
+	switch fileType {
+	case tag.MP3:
+		return mp3Stream(f)
+	case tag.FLAC:
+		return flacStream(f)
+	case tag.M4A, tag.M4B, tag.M4P, tag.ALAC:
+		return mp4Stream(f)
+	}
+	return streamInfo{}, nil
+}
+
+// kbit/s of MPEG-1 and MPEG-2 layer III frames by bitrate index
+var (
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+)
+
+// the header of an mp3 frame
+type mp3Frame struct {
+	mpeg1      bool
+	mono       bool
+	padded     bool
+	bitrate    int // kbit/s
+	sampleRate int
+}
+
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version, layer := b[1]>>3&3, b[1]>>1&3
+	bitrateIndex, rateIndex := b[2]>>4, b[2]>>2&3
+	// reserved version, only layer III, no free format or bad bitrates
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	frame := mp3Frame{mpeg1: version == 3, mono: b[3]>>6 == 3, padded: b[2]&2 != 0}
+	frame.sampleRate = []int{44100, 48000, 32000}[rateIndex]
+	frame.bitrate = mpeg1Bitrates[bitrateIndex]
+	switch version {
+	case 2: // MPEG-2
+		frame.sampleRate /= 2
+		frame.bitrate = mpeg2Bitrates[bitrateIndex]
+	case 0: // MPEG-2.5
+		frame.sampleRate /= 4
+		frame.bitrate = mpeg2Bitrates[bitrateIndex]
+	}
+	return frame, true
+}
+
+func (f mp3Frame) samples() int {
+	if f.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// bytes in the frame, header included
+func (f mp3Frame) length() int {
+	n := f.samples() / 8 * f.bitrate * 1000 / f.sampleRate
+	if f.padded {
+		n++
+	}
+	return n
+}
+
+// where the Xing or Info header sits, after the side information
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.mpeg1 && !f.mono:
+		return 4 + 32
+	case f.mpeg1, !f.mono:
+		return 4 + 17
+	}
+	return 4 + 9
+}
+
+// mp3 has no length header, so it comes from the frame count in the Xing,
+// Info or VBRI header of the first frame, or from the bitrate when there's
+// none and every frame is the same size
+func mp3Stream(r io.ReadSeeker) (streamInfo, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return streamInfo{}, err
+	}
+
+	// skip an id3v2 tag and leave out an id3v1 one
+	start := int64(0)
+	header := make([]byte, 10)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return streamInfo{}, err
+	}
+	if _, err := io.ReadFull(r, header); err == nil && string(header[:3]) == "ID3" {
+		start = 10 + int64(syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			start += 10 // footer
+		}
+	}
+	if end >= 128 {
+		trailer := make([]byte, 3)
+		if _, err := r.Seek(end-128, io.SeekStart); err != nil {
+			return streamInfo{}, err
+		}
+		if _, err := io.ReadFull(r, trailer); err == nil && string(trailer) == "TAG" {
+			end -= 128
+		}
+	}
+
+	// the first frame is usually right after the tag, but some files have
+	// padding or junk in between
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return streamInfo{}, err
+	}
+	buf := make([]byte, 64*1024)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return streamInfo{}, err
+	}
+	buf = buf[:n]
+	var frame mp3Frame
+	found := false
+	for i := range buf {
+		// a real frame is followed by another, a stray sync word isn't
+		frame, found = parseMP3Frame(buf[i:])
+		if found && i+frame.length() < len(buf) {
+			_, found = parseMP3Frame(buf[i+frame.length():])
+		}
+		if found {
+			start += int64(i)
+			buf = buf[i:]
+			break
+		}
+	}
+	if !found {
+		return streamInfo{}, errors.New("mp3: no frames")
+	}
+
+	info := streamInfo{size: end - start}
+	frames := 0
+	if x := frame.xingOffset(); len(buf) >= x+12 && (string(buf[x:x+4]) == "Xing" || string(buf[x:x+4]) == "Info") {
+		// the frame count is only there when the first flag is set
+		if buf[x+7]&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(buf[x+8 : x+12]))
+		}
+	} else if len(buf) >= 36+18 && string(buf[36:40]) == "VBRI" {
+		frames = int(binary.BigEndian.Uint32(buf[36+14 : 36+18]))
+	}
+
+	if frames > 0 {
+		info.duration = time.Duration(int64(frames) * int64(frame.samples()) * int64(time.Second) / int64(frame.sampleRate))
+	} else {
+		info.duration = time.Duration(info.size * 8 * int64(time.Second) / int64(frame.bitrate*1000))
+	}
+	return info, nil
+}
+
+// total samples and sample rate from the STREAMINFO block, and the audio
+// after the last metadata block
+func flacStream(r io.ReadSeeker) (streamInfo, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return streamInfo{}, err
+	}
+	if string(header[:4]) != "fLaC" {
+		return streamInfo{}, errors.New("flac: missing stream marker")
+	}
+	// STREAMINFO is always the first metadata block
+	if header[4]&0x7f != 0 {
+		return streamInfo{}, errors.New("flac: missing STREAMINFO")
+	}
+
+	block := make([]byte, 18)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return streamInfo{}, err
+	}
+	var info streamInfo
+	b := block[10:18]
+	sampleRate := uint64(b[0])<<12 | uint64(b[1])<<4 | uint64(b[2])>>4
+	samples := uint64(b[3]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(b[4:8]))
+	if sampleRate != 0 {
+		info.duration = time.Duration(samples * uint64(time.Second) / sampleRate)
+	}
+
+	// skip the rest of the metadata, pictures included
+	last := header[4]&0x80 != 0
+	length := int64(header[5])<<16 | int64(header[6])<<8 | int64(header[7])
+	if _, err := r.Seek(length-18, io.SeekCurrent); err != nil {
+		return streamInfo{}, err
+	}
+	for !last {
+		if _, err := io.ReadFull(r, header[:4]); err != nil {
+			return streamInfo{}, err
+		}
+		last = header[0]&0x80 != 0
+		length = int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		if _, err := r.Seek(length, io.SeekCurrent); err != nil {
+			return streamInfo{}, err
+		}
+	}
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	info.size = end - pos
+	return info, nil
+}
+
+// the length from moov/mvhd and the size of the mdat atom
+func mp4Stream(r io.ReadSeeker) (streamInfo, error) {
+	duration, err := mp4Duration(r)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return streamInfo{}, err
+	}
+	size, err := findAtom(r, "mdat", -1)
+	if err != nil {
+		return streamInfo{}, err
+	}
+	return streamInfo{duration: duration, size: size}, nil
+}
+
+// timescale and duration from the moov/mvhd atom
+func mp4Duration(r io.ReadSeeker) (time.Duration, error) {
+	size, err := findAtom(r, "moov", -1)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := findAtom(r, "mvhd", size); err != nil {
+		return 0, err
+	}
+
+	version := make([]byte, 4)
+	if _, err := io.ReadFull(r, version); err != nil {
+		return 0, err
+	}
+
+	var timescale, duration uint64
+	if version[0] == 1 {
+		b := make([]byte, 28)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[16:20]))
+		duration = binary.BigEndian.Uint64(b[20:28])
+	} else {
+		b := make([]byte, 16)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[8:12]))
+		duration = uint64(binary.BigEndian.Uint32(b[12:16]))
+	}
+	if timescale == 0 {
+		return 0, nil
+	}
+	return time.Duration(duration * uint64(time.Second) / timescale), nil
+}
+
+// skip atoms until name, leaving r at its contents and returning their
+// size. limit is how many bytes are left in the parent atom, -1 for the file
+func findAtom(r io.ReadSeeker, name string, limit int64) (int64, error) {
+	header := make([]byte, 8)
+	for limit < 0 || limit >= 8 {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, fmt.Errorf("mp4: no %s atom: %w", name, err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		if size == 1 {
+			// 64 bit size follows the name
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(r, ext); err != nil {
+				return 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(ext))
+			headerSize = 16
+		}
+		if size < headerSize {
+			return 0, fmt.Errorf("mp4: bad %q atom size", header[4:8])
+		}
+
+		if string(header[4:8]) == name {
+			return size - headerSize, nil
+		}
+		if _, err := r.Seek(size-headerSize, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+		if limit >= 0 {
+			limit -= size
+		}
+	}
+	return 0, fmt.Errorf("mp4: no %s atom", name)
+}
+
+// 3:45, or 1:02:03 for anything an hour or longer
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
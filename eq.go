@@ -0,0 +1,318 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/gopxl/beep"
+)
+
+// the eq section of the config
+type eqConfig struct {
+	Bands   []float64            `yaml:"bands"` // centre frequencies in Hz
+	Q       float64              `yaml:"q"`     // higher is narrower
+	Presets map[string][]float64 `yaml:"presets"`
+}
+
+var defaultEQBands = []float64{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+const (
+	defaultEQQ = 1.41
+	maxEQGain  = 12 // dB either way
+	flatPreset = "flat"
+	// the preset name once bands are changed by hand
+	customPreset = "custom"
+)
+
+// a point of a built-in preset's curve, in Hz and dB
+type eqPoint struct{ freq, gain float64 }
+
+// built-in presets as curves, so they fit any set of bands
+var eqCurves = []struct {
+	name   string
+	points []eqPoint
+}{
+	{flatPreset, nil},
+	{"bass boost", []eqPoint{{60, 6}, {150, 4}, {300, 1}, {600, 0}}},
+	{"vocal", []eqPoint{{100, -2}, {300, 0}, {1000, 3}, {3000, 4}, {6000, 2}, {10000, 0}}},
+	{"loudness", []eqPoint{{31, 6}, {125, 3}, {500, 0}, {2000, 0}, {8000, 2}, {16000, 4}}},
+}
+
+// the bands in use, the config's or the default ten
+func eqBands() []float64 {
+	if bands := cfg().EQ.Bands; len(bands) > 0 {
+		return bands
+	}
+	return defaultEQBands
+}
+
+func eqQ() float64 {
+	return cmp.Or(cfg().EQ.Q, defaultEQQ)
+}
+
+// the gain of a curve at a frequency, straight lines between its points on
+// a log scale and level beyond them
+func curveGain(points []eqPoint, freq float64) float64 {
+	if len(points) == 0 {
+		return 0
+	}
+	if freq <= points[0].freq {
+		return points[0].gain
+	}
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		if freq <= b.freq {
+			t := math.Log(freq/a.freq) / math.Log(b.freq/a.freq)
+			return a.gain + t*(b.gain-a.gain)
+		}
+	}
+	return points[len(points)-1].gain
+}
+
+// a preset on the EQ page
+type eqPreset struct {
+	name  string
+	gains []float64
+	err   string // why a custom preset can't be used
+}
+
+// built-in presets followed by the config's own, by name
+func eqPresets() []eqPreset {
+	bands := eqBands()
+	var presets []eqPreset
+	for _, c := range eqCurves {
+		gains := make([]float64, len(bands))
+		for i, f := range bands {
+			// adding 0 turns -0 into 0 so it doesn't show as -0 dB
+			gains[i] = math.Round(curveGain(c.points, f)) + 0
+		}
+		presets = append(presets, eqPreset{name: c.name, gains: gains})
+	}
+
+	custom := cfg().EQ.Presets
+	for _, name := range slices.Sorted(maps.Keys(custom)) {
+		p := eqPreset{name: name, gains: clampEQGains(custom[name])}
+		if len(p.gains) != len(bands) {
+			p.err = fmt.Sprintf("needs %d gains, has %d", len(bands), len(p.gains))
+		}
+		presets = append(presets, p)
+	}
+	return presets
+}
+
+// gains within what the EQ page can show, in case a preset or state.json
+// goes past them
+func clampEQGains(gains []float64) []float64 {
+	clamped := make([]float64, len(gains))
+	for i, g := range gains {
+		clamped[i] = clampEQGain(g)
+	}
+	return clamped
+}
+
+func clampEQGain(gain float64) float64 {
+	return min(max(gain, -maxEQGain), maxEQGain)
+}
+
+// a peaking filter from the Audio EQ Cookbook
+func peakingFilter(freq, gain, q, sampleRate float64) biquad {
+	a := math.Pow(10, gain/40)
+	w0 := 2 * math.Pi * freq / sampleRate
+	alpha := math.Sin(w0) / (2 * q)
+	a0 := 1 + alpha/a
+	return biquad{
+		b0: (1 + alpha*a) / a0,
+		b1: -2 * math.Cos(w0) / a0,
+		b2: (1 - alpha*a) / a0,
+		a1: -2 * math.Cos(w0) / a0,
+		a2: (1 - alpha/a) / a0,
+	}
+}
+
+// global equalizer streamer, between the normaliser and the volume
+var equalizer = &eqStreamer{preset: flatPreset}
+
+// a chain of peaking filters, one per band that isn't flat. changes are made
+// with the speaker locked while a song plays
+type eqStreamer struct {
+	Streamer   beep.Streamer
+	preset     string
+	gains      []float64
+	sampleRate float64
+	filters    [][2]biquad // per band, per channel
+	headroom   float64     // turns the sound down by the biggest boost so it can't clip
+}
+
+func (e *eqStreamer) Stream(samples [][2]float64) (int, bool) {
+	n, ok := e.Streamer.Stream(samples)
+	if len(e.filters) == 0 {
+		return n, ok
+	}
+	for i := range samples[:n] {
+		for ch := range samples[i] {
+			x := samples[i][ch] * e.headroom
+			for b := range e.filters {
+				x = e.filters[b][ch].process(x)
+			}
+			samples[i][ch] = x
+		}
+	}
+	return n, ok
+}
+
+func (e *eqStreamer) Err() error {
+	return e.Streamer.Err()
+}
+
+// use a preset, or gains set by hand under the custom name
+func (e *eqStreamer) set(preset string, gains []float64) {
+	e.preset = preset
+	e.gains = slices.Clone(gains)
+	e.build()
+}
+
+// the sample rate of a new song
+func (e *eqStreamer) setSampleRate(sr beep.SampleRate) {
+	if float64(sr) != e.sampleRate {
+		e.sampleRate = float64(sr)
+		e.build()
+	}
+}
+
+func (e *eqStreamer) build() {
+	e.filters = nil
+	e.headroom = 1
+	if e.sampleRate == 0 {
+		return
+	}
+	bands, q := eqBands(), eqQ()
+	boost := 0.0
+	for i, gain := range e.gains {
+		// bands above the highest frequency the song can hold do nothing
+		if i >= len(bands) || gain == 0 || bands[i] >= e.sampleRate/2 {
+			continue
+		}
+		f := peakingFilter(bands[i], gain, q, e.sampleRate)
+		e.filters = append(e.filters, [2]biquad{f, f})
+		boost = max(boost, gain)
+	}
+	e.headroom = math.Pow(10, -boost/20)
+}
+
+// the eq as saved in state.json, flat when the bands have changed since
+func (e *eqStreamer) restore(state playerState) {
+	if len(state.EQ) != len(eqBands()) {
+		e.set(flatPreset, make([]float64, len(eqBands())))
+		return
+	}
+	e.set(cmp.Or(state.EQPreset, customPreset), clampEQGains(state.EQ))
+}
+
+// pick up changed bands from the config, starting flat when their number
+// changed and redrawing the EQ page if it's open
+func (m model) reloadEQ() model {
+	m.withSpeaker(func() { equalizer.restore(m.state) })
+	if len(m.state.EQ) != len(equalizer.gains) {
+		m.state.EQPreset, m.state.EQ = equalizer.preset, slices.Clone(equalizer.gains)
+	}
+	m, _ = m.refreshList()
+	return m
+}
+
+// use the eq settings, remembering them
+func (m model) setEQ(preset string, gains []float64) model {
+	m.withSpeaker(func() { equalizer.set(preset, gains) })
+	state := m.state
+	state.EQPreset, state.EQ = preset, slices.Clone(gains)
+	m = m.rememberState(state)
+	m, _ = m.refreshList()
+	return m
+}
+
+// apply the selected preset on the EQ page
+func (m model) applyPreset(p eqPreset) model {
+	if p.err != "" {
+		m.status = fmt.Sprintf("can't use preset %s: %s", p.name, p.err)
+		return m.resize()
+	}
+	return m.setEQ(p.name, p.gains)
+}
+
+// raise or lower the selected band by a dB
+func (m model) adjustBand(delta float64) model {
+	band, ok := m.list.SelectedItem().(eqBand)
+	// the page can be behind a config change for a moment
+	if !ok || band.index >= len(equalizer.gains) {
+		return m
+	}
+	gains := slices.Clone(equalizer.gains)
+	gains[band.index] = clampEQGain(gains[band.index] + delta)
+	return m.setEQ(customPreset, gains)
+}
+
+func (m model) showEQ() model {
+	p := page{kind: eqPage, title: "Equalizer"}
+	return m.switchPage(eqPage).setPage(p, p.items())
+}
+
+func (p eqPreset) Title() string { return p.name }
+func (p eqPreset) Description() string {
+	switch {
+	case p.err != "":
+		return p.err
+	case p.name == equalizer.preset:
+		return "in use"
+	}
+	return ""
+}
+func (p eqPreset) FilterValue() string { return p.name }
+
+// a band on the EQ page
+type eqBand struct {
+	index int
+	freq  float64
+	gain  float64
+}
+
+func (b eqBand) Title() string {
+	if b.freq >= 1000 {
+		return fmt.Sprintf("%g kHz", b.freq/1000)
+	}
+	return fmt.Sprintf("%g Hz", b.freq)
+}
+func (b eqBand) Description() string {
+	return fmt.Sprintf("%s %+.0f dB", eqBar(b.gain), b.gain)
+}
+func (b eqBand) FilterValue() string { return b.Title() }
+
+// a bar growing left or right from the middle, a block per 2 dB
+func eqBar(gain float64) string {
+	half := maxEQGain / 2
+	n := int(math.Round(math.Abs(clampEQGain(gain)) / 2))
+	if gain < 0 {
+		return strings.Repeat("─", half-n) + strings.Repeat("█", n) + "│" + strings.Repeat("─", half)
+	}
+	return strings.Repeat("─", half) + "│" + strings.Repeat("█", n) + strings.Repeat("─", half-n)
+}
+
+// presets, then the bands of the current settings
+func eqItems() []list.Item {
+	items := []list.Item{heading{"Presets"}}
+	for _, p := range eqPresets() {
+		items = append(items, p)
+	}
+	items = append(items, heading{"Bands · " + keys.EQDown.Help().Key + " and " + keys.EQUp.Help().Key + " adjust"})
+	for i, f := range eqBands() {
+		var gain float64
+		if i < len(equalizer.gains) {
+			gain = equalizer.gains[i]
+		}
+		items = append(items, eqBand{index: i, freq: f, gain: gain})
+	}
+	return items
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// one play of a track, a line of history.jsonl
+type play struct {
+	Path     string    `json:"path"`
+	Title    string    `json:"title"`
+	Artist   string    `json:"artist"`
+	Album    string    `json:"album,omitempty"`
+	Started  time.Time `json:"started"`
+	Listened float64   `json:"listened"` // seconds actually heard, pauses and seeks left out
+	Skipped  bool      `json:"skipped"`  // stopped before the end
+	Length   float64   `json:"length,omitempty"`
+}
+
+func (p play) listened() time.Duration {
+	return time.Duration(p.Listened * float64(time.Second))
+}
+
+// whether the play counts as one, like a scrobble: it was finished or heard
+// long enough. older logs have no length, so skips there need four minutes
+func (p play) counts() bool {
+	if !p.Skipped {
+		return true
+	}
+	length := time.Duration(p.Length * float64(time.Second))
+	if p.Length == 0 {
+		length = 2 * maxScrobbleWait
+	}
+	return heardEnough(p.listened(), length)
+}
+
+// every play so far, read from the log at start and added to as songs are
+// played, so the stats page doesn't read the whole log again. only used by
+// the ui
+var history []play
+
+func historyPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// append a play to the history log. the log is only ever appended to, so a
+// crash can at worst cut off the last line
+func appendHistory(p play) error {
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// every play in the log, oldest first. broken lines are skipped
+func readHistory() ([]play, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var plays []play
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var p play
+		if json.Unmarshal(scanner.Bytes(), &p) == nil && p.Path != "" {
+			plays = append(plays, p)
+		}
+	}
+	return plays, scanner.Err()
+}
+
+// read the log and count its plays so play count sorting works from the
+// start. skips count only if enough of the song was heard
+func loadPlayCounts() error {
+	plays, err := readHistory()
+	for _, p := range plays {
+		if p.counts() {
+			lib.addPlay(p.Path)
+		}
+	}
+	history = plays
+	return err
+}
+
+// log the song that was playing, if any. finished is false when it was cut
+// short by another song or quitting
+func (m model) recordPlay(finished bool) model {
+	if m.playStarted.IsZero() {
+		return m
+	}
+	p := play{
+		Path:     m.currPlaying.path,
+		Title:    m.currPlaying.title,
+		Artist:   m.currPlaying.artist,
+		Album:    m.currPlaying.album,
+		Started:  m.playStarted,
+		Listened: m.listened.Seconds(),
+		Skipped:  !finished,
+		Length:   m.total.Seconds(),
+	}
+	m.playStarted = time.Time{}
+	m.listened = 0
+
+	if p.counts() {
+		lib.addPlay(p.Path)
+	}
+	history = append(history, p)
+	if err := appendHistory(p); err != nil {
+		m.status = "can't write history: " + err.Error()
+	}
+	return m
+}
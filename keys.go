@@ -1,6 +1,17 @@
 package main
 
-import "github.com/charmbracelet/bubbles/key"
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"gopkg.in/yaml.v3"
+)
 
 // ShortHelp returns keybindings to be shown in the mini help view. It's part
 // of the key.Map interface.
@@ -13,9 +24,12 @@ func (k keyMap) ShortHelp() []key.Binding {
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.Next, k.Prev},
-		{k.Albums, k.Songs, k.Artists, k.Playing},
-		{k.Play, k.Pause, k.Forward, k.Rewind},
-		{k.Help, k.Quit, k.Increase, k.Decrease},
+		{k.Albums, k.Songs, k.Artists, k.Genres, k.Years, k.Playing},
+		{k.Playlists, k.Stats, k.Sort, k.Search, k.SaveSearch, k.Back, k.ForwardPage},
+		{k.Play, k.Pause, k.Enqueue, k.Forward, k.Rewind, k.Rate, k.Favourite},
+		{k.Help, k.Quit, k.Increase, k.Decrease, k.Mute},
+		{k.EQ, k.EQUp, k.EQDown},
+		{k.Layout, k.StopScan, k.Problems, k.Retry},
 	}
 }
 
@@ -29,92 +43,426 @@ type keyMap struct {
 	Pause   key.Binding
 	Next    key.Binding
 	Prev    key.Binding
+	Enqueue key.Binding
 	Forward key.Binding
 	Rewind  key.Binding
 
+	// ratings, the keys of Rate give 0 to 5 stars in order
+	Rate      key.Binding
+	Favourite key.Binding
+
 	// volume control
 	Increase key.Binding
 	Decrease key.Binding
+	Mute     key.Binding
+
+	// equalizer
+	EQ     key.Binding
+	EQUp   key.Binding
+	EQDown key.Binding
 
 	// page navigation
-	Albums  key.Binding
-	Songs   key.Binding
-	Artists key.Binding
-	Playing key.Binding
-	Help    key.Binding
-	Quit    key.Binding
+	Albums      key.Binding
+	Songs       key.Binding
+	Artists     key.Binding
+	Playing     key.Binding
+	Genres      key.Binding
+	Years       key.Binding
+	Playlists   key.Binding
+	Stats       key.Binding
+	Sort        key.Binding
+	Search      key.Binding
+	SaveSearch  key.Binding
+	Back        key.Binding
+	ForwardPage key.Binding
+	Help        key.Binding
+	Quit        key.Binding
+
+	// library
+	StopScan key.Binding
+	Problems key.Binding
+	Retry    key.Binding
+
+	// appearance
+	Layout key.Binding
 }
 
-var keys = keyMap{
-	// list navigation
-	Up: key.NewBinding(
-		key.WithKeys("up", "k"),
-		key.WithHelp("↑/k", "move up"),
-	),
-	Down: key.NewBinding(
-		key.WithKeys("down", "j"),
-		key.WithHelp("↓/j", "move down"),
-	),
+// the bindings in use, defaults with the config's keys section applied
+var keys = defaultKeys()
 
-	// playback control
-	Play: key.NewBinding(
-		key.WithKeys("enter"),
-		key.WithHelp("enter", "play"),
-	),
-	Pause: key.NewBinding(
-		key.WithKeys("space"),
-		key.WithHelp("space", "pause/resume"),
-	),
-	Next: key.NewBinding(
-		key.WithKeys("n"),
-		key.WithHelp("n", "next song"),
-	),
-	Prev: key.NewBinding(
-		key.WithKeys("p"),
-		key.WithHelp("p", "prev song"),
-	),
-	Forward: key.NewBinding(
-		key.WithKeys("right"),
-		key.WithHelp("→", "fast forward"),
-	),
-	Rewind: key.NewBinding(
-		key.WithKeys("left"),
-		key.WithHelp("←", "rewind"),
-	),
+func defaultKeys() keyMap {
+	return keyMap{
+		// list navigation
+		Up: key.NewBinding(
+			key.WithKeys("up", "k"),
+			key.WithHelp("↑/k", "move up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys("down", "j"),
+			key.WithHelp("↓/j", "move down"),
+		),
 
-	// volume control
-	Increase: key.NewBinding(
-		key.WithKeys("+"),
-		key.WithHelp("+", "increase volume"),
-	),
-	Decrease: key.NewBinding(
-		key.WithKeys("-"),
-		key.WithHelp("-", "decrease volume"),
-	),
+		// playback control
+		Play: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "play"),
+		),
+		Pause: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "pause/resume"),
+		),
+		Next: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "next song"),
+		),
+		Prev: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "prev song"),
+		),
+		Enqueue: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "enqueue"),
+		),
+		Forward: key.NewBinding(
+			key.WithKeys("right", ">"),
+			key.WithHelp("→/>", "fast forward"),
+		),
+		Rewind: key.NewBinding(
+			key.WithKeys("left", "<"),
+			key.WithHelp("←/<", "rewind"),
+		),
 
-	// page navigation
-	Albums: key.NewBinding(
-		key.WithKeys("a"),
-		key.WithHelp("a", "album"),
-	),
-	Songs: key.NewBinding(
-		key.WithKeys("s"),
-		key.WithHelp("s", "songs"),
-	),
-	Artists: key.NewBinding(
-		key.WithKeys("d"),
-		key.WithHelp("d", "artists"),
-	),
-	Playing: key.NewBinding(
-		key.WithKeys("f"),
-		key.WithHelp("f", "playing"),
-	),
-	Help: key.NewBinding(
-		key.WithKeys("?"),
-		key.WithHelp("?", "toggle help"),
-	),
-	Quit: key.NewBinding(
-		key.WithKeys("q", "ctrl+c"),
-		key.WithHelp("q/ctrl+c", "quit"),
-	),
+		// ratings
+		Rate: key.NewBinding(
+			key.WithKeys("0", "1", "2", "3", "4", "5"),
+			key.WithHelp("0-5", "rate"),
+		),
+		Favourite: key.NewBinding(
+			key.WithKeys("*"),
+			key.WithHelp("*", "favourite"),
+		),
+
+		// volume control
+		Increase: key.NewBinding(
+			key.WithKeys("+"),
+			key.WithHelp("+", "increase volume"),
+		),
+		Decrease: key.NewBinding(
+			key.WithKeys("-"),
+			key.WithHelp("-", "decrease volume"),
+		),
+		Mute: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "mute"),
+		),
+
+		// equalizer
+		EQ: key.NewBinding(
+			key.WithKeys("="),
+			key.WithHelp("=", "equalizer"),
+		),
+		EQUp: key.NewBinding(
+			key.WithKeys("]"),
+			key.WithHelp("]", "raise band"),
+		),
+		EQDown: key.NewBinding(
+			key.WithKeys("["),
+			key.WithHelp("[", "lower band"),
+		),
+
+		// page navigation
+		Albums: key.NewBinding(
+			key.WithKeys("a"),
+			key.WithHelp("a", "album"),
+		),
+		Songs: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "songs"),
+		),
+		Artists: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "artists"),
+		),
+		Playing: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "playing"),
+		),
+		Genres: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "genres"),
+		),
+		Years: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "years"),
+		),
+		Playlists: key.NewBinding(
+			key.WithKeys("l"),
+			key.WithHelp("l", "playlists"),
+		),
+		Stats: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "stats"),
+		),
+		Sort: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "sort order"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys("ctrl+f"),
+			key.WithHelp("ctrl+f", "search"),
+		),
+		SaveSearch: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "save as smart playlist"),
+		),
+		Back: key.NewBinding(
+			key.WithKeys("esc", "backspace"),
+			key.WithHelp("esc", "back"),
+		),
+		ForwardPage: key.NewBinding(
+			key.WithKeys("alt+right"),
+			key.WithHelp("alt+right", "forward"),
+		),
+		Help: key.NewBinding(
+			key.WithKeys("?"),
+			key.WithHelp("?", "toggle help"),
+		),
+		Quit: key.NewBinding(
+			key.WithKeys("q", "ctrl+c"),
+			key.WithHelp("q/ctrl+c", "quit"),
+		),
+
+		// library
+		StopScan: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "stop scanning"),
+		),
+		Problems: key.NewBinding(
+			key.WithKeys("!"),
+			key.WithHelp("!", "problems"),
+		),
+		Retry: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "rescan library"),
+		),
+
+		// appearance
+		Layout: key.NewBinding(
+			key.WithKeys("v"),
+			key.WithHelp("v", "switch layout"),
+		),
+	}
+}
+
+// the keys section of the config, each action takes one key, a list of
+// keys or space separated sequences like "g g"
+type keysConfig struct {
+	Up          keyList `yaml:"up"`
+	Down        keyList `yaml:"down"`
+	Play        keyList `yaml:"play"`
+	Pause       keyList `yaml:"pause"`
+	Next        keyList `yaml:"next"`
+	Prev        keyList `yaml:"prev"`
+	Enqueue     keyList `yaml:"enqueue"`
+	Forward     keyList `yaml:"forward"`
+	Rewind      keyList `yaml:"rewind"`
+	Rate        keyList `yaml:"rate"`
+	Favourite   keyList `yaml:"favourite"`
+	Increase    keyList `yaml:"increase"`
+	Decrease    keyList `yaml:"decrease"`
+	Mute        keyList `yaml:"mute"`
+	EQ          keyList `yaml:"eq"`
+	EQUp        keyList `yaml:"eq_up"`
+	EQDown      keyList `yaml:"eq_down"`
+	Albums      keyList `yaml:"albums"`
+	Songs       keyList `yaml:"songs"`
+	Artists     keyList `yaml:"artists"`
+	Playing     keyList `yaml:"playing"`
+	Genres      keyList `yaml:"genres"`
+	Years       keyList `yaml:"years"`
+	Playlists   keyList `yaml:"playlists"`
+	Stats       keyList `yaml:"stats"`
+	Sort        keyList `yaml:"sort"`
+	Search      keyList `yaml:"search"`
+	SaveSearch  keyList `yaml:"save_search"`
+	Back        keyList `yaml:"back"`
+	ForwardPage keyList `yaml:"forward_page"`
+	Help        keyList `yaml:"help"`
+	Quit        keyList `yaml:"quit"`
+	StopScan    keyList `yaml:"stop_scan"`
+	Problems    keyList `yaml:"problems"`
+	Retry       keyList `yaml:"retry"`
+	Layout      keyList `yaml:"layout"`
+}
+
+type keyList []string
+
+// accept both "next: n" and "next: [n, ctrl+n]"
+func (k *keyList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*k = keyList{value.Value}
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*k = list
+	if *k == nil {
+		*k = keyList{} // an empty list unbinds the action
+	}
+	return nil
+}
+
+// bubbletea names the space bar " "
+func normalizeKey(k string) string {
+	tokens := strings.Fields(k)
+	for i, t := range tokens {
+		if t == "space" {
+			tokens[i] = " "
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// the default bindings with the ones from the config swapped in
+func buildKeyMap(c keysConfig) (keyMap, error) {
+	km := defaultKeys()
+	kv := reflect.ValueOf(&km).Elem()
+	cv := reflect.ValueOf(c)
+
+	for i := range cv.NumField() {
+		list := cv.Field(i).Interface().(keyList)
+		if list == nil {
+			continue
+		}
+
+		binding := kv.FieldByName(cv.Type().Field(i).Name).Addr().Interface().(*key.Binding)
+		if len(list) == 0 {
+			binding.SetEnabled(false)
+			continue
+		}
+
+		normalized := make([]string, len(list))
+		for j, k := range list {
+			normalized[j] = normalizeKey(k)
+		}
+		binding.SetKeys(normalized...)
+		binding.SetHelp(strings.Join(list, "/"), binding.Help().Desc)
+	}
+
+	return km, km.conflicts()
+}
+
+// name of every action as written in the keys section
+func (k keyMap) actions() map[string]key.Binding {
+	actions := make(map[string]key.Binding)
+	kv := reflect.ValueOf(k)
+	ct := reflect.TypeOf(keysConfig{})
+	for i := range ct.NumField() {
+		field := ct.Field(i)
+		actions[field.Tag.Get("yaml")] = kv.FieldByName(field.Name).Interface().(key.Binding)
+	}
+	return actions
+}
+
+// keys bound to two actions, and keys that start a sequence bound to
+// another action, which could never be told apart. also more rate keys than
+// there are star counts
+func (k keyMap) conflicts() error {
+	owners := make(map[string]string)
+	var problems []string
+	for name, b := range k.actions() {
+		if !b.Enabled() {
+			continue
+		}
+		for _, seq := range b.Keys() {
+			if other, ok := owners[seq]; ok && other != name {
+				problems = append(problems, fmt.Sprintf("keys: %q is bound to both %s and %s", seq, min(name, other), max(name, other)))
+			}
+			owners[seq] = name
+		}
+	}
+
+	for seq, name := range owners {
+		for other, otherName := range owners {
+			if strings.HasPrefix(other, seq+" ") {
+				problems = append(problems, fmt.Sprintf("keys: %q (%s) starts the sequence %q (%s)", seq, name, other, otherName))
+			}
+		}
+	}
+
+	if k.Rate.Enabled() && len(k.Rate.Keys()) > maxStars+1 {
+		problems = append(problems, fmt.Sprintf("keys: rate takes at most %d keys, for 0 to %d stars", maxStars+1, maxStars))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	slices.Sort(problems)
+	return errors.New(strings.Join(slices.Compact(problems), "\n"))
+}
+
+// whether seq is a whole binding or the start of one
+func (k keyMap) matchSequence(seq string) (complete, prefix bool) {
+	for _, b := range k.actions() {
+		if !b.Enabled() {
+			continue
+		}
+		for _, bound := range b.Keys() {
+			complete = complete || bound == seq
+			prefix = prefix || strings.HasPrefix(bound, seq+" ")
+		}
+	}
+	return complete, prefix
+}
+
+// collect the keys of multi-key bindings like "g g". a finished sequence is
+// handed back as a single key named after the whole sequence, so key.Matches
+// works on it like on any other key. ok is false while a sequence is pending
+func (m model) sequenceKey(msg tea.KeyMsg) (model, tea.KeyMsg, bool) {
+	pending := m.pendingKeys
+	m.pendingKeys = nil
+
+	seq := strings.Join(append(pending, msg.String()), " ")
+	complete, prefix := keys.matchSequence(seq)
+	switch {
+	case complete && len(pending) > 0:
+		return m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(seq)}, true
+	case complete:
+		return m, msg, true
+	case prefix:
+		m.pendingKeys = append(pending, msg.String())
+		return m, msg, false
+	case len(pending) > 0:
+		// the sequence broke off, use the key on its own
+		return m.sequenceKey(msg)
+	}
+	return m, msg, true
+}
+
+// the list's own bindings, following the user's keys. paging, jumping and
+// filter keys that a podden action uses are left out, so a key never does
+// two things at once
+func listKeys() list.KeyMap {
+	km := list.DefaultKeyMap()
+	km.CursorUp = keys.Up
+	km.CursorDown = keys.Down
+	km.Quit = keys.Quit
+
+	taken := make(map[string]bool)
+	for _, b := range keys.actions() {
+		if b.Enabled() {
+			for _, k := range b.Keys() {
+				taken[k] = true
+			}
+		}
+	}
+	for _, b := range []*key.Binding{&km.PrevPage, &km.NextPage, &km.GoToStart, &km.GoToEnd, &km.Filter, &km.ShowFullHelp, &km.CloseFullHelp} {
+		free := slices.DeleteFunc(slices.Clone(b.Keys()), func(k string) bool { return taken[k] })
+		b.SetKeys(free...)
+		b.SetEnabled(len(free) > 0)
+	}
+	return km
 }
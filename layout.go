@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+	zone "github.com/lrstanley/bubblezone"
+)
+
+type layoutMode int
+
+const (
+	layoutCompact layoutMode = iota // ipod sized screen in the middle of the terminal
+	layoutFull                      // one screen at a time, stretched to the terminal
+	layoutWide                      // library, now playing and lyrics side by side
+)
+
+// size of the classic ipod screen
+const (
+	compactWidth  = 34
+	compactHeight = 16
+)
+
+// wide mode needs room for three panes, fall back to full below this
+const minWideWidth = 90
+
+func parseLayout(value string) layoutMode {
+	switch value {
+	case "compact":
+		return layoutCompact
+	case "wide":
+		return layoutWide
+	default:
+		return layoutFull
+	}
+}
+
+func (l layoutMode) String() string {
+	switch l {
+	case layoutCompact:
+		return "compact"
+	case layoutWide:
+		return "wide"
+	default:
+		return "full"
+	}
+}
+
+// cycle compact -> full -> wide
+func (l layoutMode) next() layoutMode {
+	return (l + 1) % 3
+}
+
+// the layout actually used for the current window size
+func (m model) effectiveLayout() layoutMode {
+	if m.layout == layoutWide && m.width < minWideWidth {
+		return layoutFull
+	}
+	return m.layout
+}
+
+// height left for screens once the status lines and help menu are drawn
+func (m model) bodyHeight() int {
+	h := m.height
+	if status := m.statusView(); status != "" {
+		h -= lipgloss.Height(status)
+	}
+	if cfg().ShowHelp {
+		h -= lipgloss.Height(helpMenu.Render(m.help.View(keys)))
+	}
+	return max(h, 0)
+}
+
+// outer size of the main screen in compact and full layouts
+func (m model) screenSize() (int, int) {
+	width, height := m.width, m.bodyHeight()
+	if m.effectiveLayout() == layoutCompact {
+		width = min(width, compactWidth)
+		height = min(height, compactHeight)
+	}
+	return width, height
+}
+
+// outer widths of the library, now playing and lyrics panes in wide layout
+func (m model) paneWidths() (int, int, int) {
+	libraryWidth := m.width * 2 / 5
+	playingWidth := (m.width - libraryWidth) / 2
+	lyricsWidth := m.width - libraryWidth - playingWidth
+	return libraryWidth, playingWidth, lyricsWidth
+}
+
+// outer size of the pane holding the list
+func (m model) listPaneSize() (int, int) {
+	if m.effectiveLayout() == layoutWide {
+		libraryWidth, _, _ := m.paneWidths()
+		return libraryWidth, m.bodyHeight()
+	}
+	return m.screenSize()
+}
+
+// size available to content inside a screen of the given outer size
+func innerSize(width, height int) (int, int) {
+	frameWidth, frameHeight := screenStyle.GetFrameSize()
+	return max(width-frameWidth, 1), max(height-frameHeight, 1)
+}
+
+// draw content inside a screen of the given outer size
+func renderScreen(content string, width, height int) string {
+	return screenStyle.
+		Width(max(width-screenStyle.GetHorizontalBorderSize(), 0)).
+		Height(max(height-screenStyle.GetVerticalBorderSize(), 0)).
+		MaxHeight(height).
+		Render(content)
+}
+
+// create a list that fits the current layout
+func (m model) newList(items []list.Item, title string) list.Model {
+	width, height := innerSize(m.listPaneSize())
+	l := list.New(items, customDelegate(), width, height)
+	l.Title = title
+	l.Styles = setCustomBubblesStyle()
+	l.KeyMap = listKeys()
+	return l
+}
+
+// resize the list and help to the current window
+func (m model) resize() model {
+	m.help.Width = m.width
+	if m.loaded || m.showAlbums || m.showArtists {
+		m.list.SetSize(innerSize(m.listPaneSize()))
+	}
+	return m
+}
+
+// title, artist, progress and volume of the current song
+func (m model) nowPlayingView(width int) string {
+	timeInfo := fmt.Sprintf("%s / %s", m.elapsed, m.total)
+	volumeInfo := m.volumeView()
+	gap := max(width-lipgloss.Width(timeInfo)-lipgloss.Width(volumeInfo), 1)
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		titleStyle.MaxWidth(width).Render(m.currPlaying.title),
+		artistStyle.MaxWidth(width).Render(m.currPlaying.artist),
+		"",
+		zone.Mark(progressZone, progressView(m.elapsed, m.total, width)),
+		timeStyle.MaxWidth(width).Render(timeInfo+strings.Repeat(" ", gap)+volumeInfo),
+	)
+}
+
+// a bar filled up to the elapsed time
+func progressView(elapsed, total time.Duration, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = min(int(float64(width)*elapsed.Seconds()/total.Seconds()), width)
+	}
+	return progressStyle.Render(strings.Repeat("━", filled)) +
+		progressTrackStyle.Render(strings.Repeat("─", width-filled))
+}
+
+// the playing screen used by the compact and full layouts
+func (m model) playingView() string {
+	width, height := m.screenSize()
+	innerWidth, _ := innerSize(width, height)
+
+	content := lipgloss.JoinVertical(
+		lipgloss.Left,
+		m.nowPlayingView(innerWidth),
+		"",
+		"",
+		lyricStyle.Width(innerWidth).Render(m.currLyric),
+	)
+	screen := renderScreen(content, width, height)
+
+	if m.img != nil {
+		cover, _ := m.img.Render()
+		screen = lipgloss.JoinHorizontal(lipgloss.Top, screen, cover)
+	}
+	return screen
+}
+
+// index of the lyric line being sung, -1 before the first line
+func (m model) lyricIndex() int {
+	index := -1
+	for i, l := range m.lyrics {
+		if m.elapsed.Seconds() < l.Time {
+			break
+		}
+		index = i
+	}
+	return index
+}
+
+// all lyric lines around the current one, the current line highlighted
+func (m model) lyricsView(width, height int) string {
+	if len(m.lyrics) == 0 {
+		return lyricStyle.Width(width).Render(m.currLyric)
+	}
+
+	current := m.lyricIndex()
+	start := max(current-height/2, 0)
+	end := min(start+height, len(m.lyrics))
+
+	lines := make([]string, 0, end-start)
+	for i := start; i < end; i++ {
+		style := artistStyle
+		if i == current {
+			style = lyricStyle
+		}
+		lines = append(lines, style.Width(width).MaxHeight(1).Align(lipgloss.Center).Render(m.lyrics[i].Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// library, now playing and lyrics side by side
+func (m model) wideView() string {
+	libraryWidth, playingWidth, lyricsWidth := m.paneWidths()
+	height := m.bodyHeight()
+
+	library := "loading..."
+	if m.loaded || m.showAlbums || m.showArtists {
+		library = zone.Mark(listZone, m.list.View())
+	}
+
+	playing := "nothing playing"
+	if m.currPlaying.path != "" {
+		innerWidth, _ := innerSize(playingWidth, height)
+		playing = m.nowPlayingView(innerWidth)
+		if m.img != nil {
+			cover, _ := m.img.Render()
+			playing = lipgloss.JoinVertical(lipgloss.Left, playing, "", cover)
+		}
+	}
+
+	lyricsInnerWidth, lyricsInnerHeight := innerSize(lyricsWidth, height)
+	lyrics := m.lyricsView(lyricsInnerWidth, lyricsInnerHeight)
+
+	return lipgloss.JoinHorizontal(
+		lipgloss.Top,
+		renderScreen(library, libraryWidth, height),
+		renderScreen(playing, playingWidth, height),
+		renderScreen(lyrics, lyricsWidth, height),
+	)
+}
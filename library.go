@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// the library section of the config
+type libraryConfig struct {
+	Dirs           []string `yaml:"dirs"`
+	Exclude        []string `yaml:"exclude"`
+	FollowSymlinks bool     `yaml:"follow_symlinks"`
+	MaxDepth       int      `yaml:"max_depth"`
+	WriteRatings   bool     `yaml:"write_ratings"`
+}
+
+// whether two configs pick the same files for the library
+func (c libraryConfig) sameFiles(other libraryConfig) bool {
+	return slices.Equal(c.Dirs, other.Dirs) && slices.Equal(c.Exclude, other.Exclude) &&
+		c.FollowSymlinks == other.FollowSymlinks && c.MaxDepth == other.MaxDepth
+}
+
+// file types podden knows how to read
+var audioExts = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".m4a":  true,
+}
+
+func isAudioFile(p string) bool {
+	return audioExts[strings.ToLower(filepath.Ext(p))]
+}
+
+// expand a leading ~ to the home directory
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(homeDir, p[1:])
+}
+
+// directories to scan, -m wins over the config for one-off runs
+func libraryDirs() []string {
+	if *musicDirFlag != "" {
+		return []string{*musicDirFlag}
+	}
+
+	dirs := cfg().Library.Dirs
+	if len(dirs) == 0 {
+		dirs = []string{"~/Music"}
+	}
+
+	expanded := make([]string, len(dirs))
+	for i, dir := range dirs {
+		expanded[i] = expandHome(dir)
+	}
+	return expanded
+}
+
+// every track in the library by path, filled by the first scan and kept up
+// to date by the watcher
+var lib = &libraryIndex{
+	tracks:   make(map[string]music),
+	problems: make(map[string]problem),
+	plays:    make(map[string]int),
+	ratings:  make(map[string]rating),
+	ready:    make(chan struct{}),
+}
+
+type libraryIndex struct {
+	mu       sync.RWMutex
+	tracks   map[string]music
+	problems map[string]problem // files and directories that couldn't be read
+	plays    map[string]int     // times each path was played
+	ratings  map[string]rating  // stars and favourites set in podden
+	ready    chan struct{}      // closed once the first scan is done
+	once     sync.Once
+}
+
+// add tracks read by a scan
+func (l *libraryIndex) add(musics []music) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range musics {
+		l.tracks[m.path] = m
+		delete(l.problems, m.path)
+	}
+}
+
+// remember files that failed to scan or play, replacing older problems
+func (l *libraryIndex) addProblems(problems []problem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range problems {
+		l.problems[p.path] = p
+	}
+}
+
+// forget every problem before scanning again
+func (l *libraryIndex) clearProblems() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.problems)
+}
+
+// every problem sorted by path
+func (l *libraryIndex) allProblems() []problem {
+	l.mu.RLock()
+	problems := make([]problem, 0, len(l.problems))
+	for _, p := range l.problems {
+		problems = append(problems, p)
+	}
+	l.mu.RUnlock()
+
+	slices.SortFunc(problems, func(a, b problem) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return problems
+}
+
+func (l *libraryIndex) problemCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.problems)
+}
+
+func (l *libraryIndex) addPlay(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.plays[path]++
+}
+
+func (l *libraryIndex) playCount(path string) int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.plays[path]
+}
+
+// the rating set in podden, or the one from the file's tags
+func (l *libraryIndex) ratingOf(m music) rating {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if r, ok := l.ratings[m.path]; ok {
+		return r
+	}
+	return rating{Stars: m.tagRating}
+}
+
+func (l *libraryIndex) setRating(path string, r rating) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ratings[path] = r
+}
+
+// a copy of every rating set in podden, for saving
+func (l *libraryIndex) allRatings() map[string]rating {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return maps.Clone(l.ratings)
+}
+
+// swap in a measured gain for a track, if it's still in the library
+func (l *libraryIndex) setGain(path string, rg replayGain) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if m, ok := l.tracks[path]; ok {
+		m.gain = rg
+		l.tracks[path] = m
+	}
+}
+
+// let the watcher start once the first scan is over
+func (l *libraryIndex) markReady() {
+	l.once.Do(func() { close(l.ready) })
+}
+
+func (l *libraryIndex) set(m music) {
+	l.add([]music{m})
+}
+
+// remove the track at p, or every track below p if it was a directory,
+// with their problems. reports whether anything was removed
+func (l *libraryIndex) remove(p string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := false
+	prefix := p + string(filepath.Separator)
+	for path := range l.tracks {
+		if path == p || strings.HasPrefix(path, prefix) {
+			delete(l.tracks, path)
+			removed = true
+		}
+	}
+	for path := range l.problems {
+		if path == p || strings.HasPrefix(path, prefix) {
+			delete(l.problems, path)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// every track sorted by path
+func (l *libraryIndex) all() []music {
+	l.mu.RLock()
+	musics := make([]music, 0, len(l.tracks))
+	for _, m := range l.tracks {
+		musics = append(musics, m)
+	}
+	l.mu.RUnlock()
+
+	slices.SortFunc(musics, func(a, b music) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return musics
+}
+
+type libraryWalker struct {
+	exclude        []string
+	followSymlinks bool
+	maxDepth       int
+	visited        map[string]bool // real paths of walked directories
+	seen           map[string]bool // files already reported
+	fn             func(path string)
+	dirFn          func(path string)            // optional, called for every directory
+	errFn          func(path string, err error) // optional, called for unreadable paths
+	ctx            context.Context              // stops the walk when done
+}
+
+func newLibraryWalker(fn, dirFn func(path string)) *libraryWalker {
+	c := cfg().Library
+	return &libraryWalker{
+		exclude:        c.Exclude,
+		followSymlinks: c.FollowSymlinks,
+		maxDepth:       c.MaxDepth,
+		visited:        make(map[string]bool),
+		seen:           make(map[string]bool),
+		fn:             fn,
+		dirFn:          dirFn,
+		ctx:            context.Background(),
+	}
+}
+
+// call fn for every audio file in the library directories until ctx is
+// done, and errFn for every file or directory that couldn't be read
+func walkLibrary(ctx context.Context, fn func(path string), errFn func(path string, err error)) {
+	w := newLibraryWalker(fn, nil)
+	w.ctx = ctx
+	w.errFn = errFn
+	for _, dir := range libraryDirs() {
+		w.walk(filepath.Clean(dir), filepath.Clean(dir))
+	}
+}
+
+// the library directory p is in
+func rootOf(p string) (string, bool) {
+	for _, dir := range libraryDirs() {
+		dir = filepath.Clean(dir)
+		rel, err := filepath.Rel(dir, p)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// whether the file p belongs in the library
+func (w *libraryWalker) includes(root, p string) bool {
+	if !isAudioFile(p) || w.excluded(root, p) {
+		return false
+	}
+	return filepath.Dir(p) == root || w.allowed(root, filepath.Dir(p))
+}
+
+// walk dir, which is root or a symlinked directory below it
+func (w *libraryWalker) walk(root, dir string) {
+	// WalkDir doesn't descend into a link it was given, so walk the real
+	// directory and report paths as seen through the link
+	target, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		w.report(dir, err)
+		return
+	}
+	if w.visited[target] {
+		return
+	}
+	w.visited[target] = true
+
+	filepath.WalkDir(target, func(p string, d fs.DirEntry, err error) error {
+		if w.ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if rel, err := filepath.Rel(target, p); err == nil {
+			p = filepath.Join(dir, rel)
+		}
+		if err != nil {
+			w.report(p, err)
+			return nil
+		}
+
+		if d.IsDir() {
+			if p != dir && !w.allowed(root, p) {
+				return filepath.SkipDir
+			}
+			if w.dirFn != nil {
+				w.dirFn(p)
+			}
+			return nil
+		}
+
+		// WalkDir never follows symlinks, walk linked directories ourselves
+		if d.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(p); err == nil && info.IsDir() {
+				if w.followSymlinks && w.allowed(root, p) {
+					w.walk(root, p)
+				}
+				return nil
+			}
+		}
+
+		if !isAudioFile(p) || w.excluded(root, p) {
+			return nil
+		}
+
+		// overlapping directories or links can lead to the same file twice
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			abs = p
+		}
+		if w.seen[abs] {
+			return nil
+		}
+		w.seen[abs] = true
+
+		if w.fn != nil {
+			w.fn(p)
+		}
+		return nil
+	})
+}
+
+func (w *libraryWalker) report(p string, err error) {
+	if w.errFn != nil {
+		w.errFn(p, err)
+	}
+}
+
+// whether the directory p is within max depth and not excluded
+func (w *libraryWalker) allowed(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	depth := len(strings.Split(filepath.ToSlash(rel), "/"))
+	if w.maxDepth > 0 && depth > w.maxDepth {
+		return false
+	}
+	return !w.excluded(root, p)
+}
+
+// match exclude patterns against the path starting at the root directory's
+// name, so "*/Podcasts/*" excludes every Podcasts folder and "*.part" every
+// partial download. a pattern matches any run of path components
+func (w *libraryWalker) excluded(root, p string) bool {
+	rel, err := filepath.Rel(filepath.Dir(root), p)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	for _, pattern := range w.exclude {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		n := strings.Count(pattern, "/") + 1
+
+		for i := 0; i+n <= len(parts); i++ {
+			if ok, _ := path.Match(pattern, strings.Join(parts[i:i+n], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep/mp3"
+)
+
+// ReplayGain 2.0 plays everything at -18 LUFS
+const replayGainReference = -18
+
+// a second order iir filter, direct form II transposed
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// the K-weighting filters of ITU-R BS.1770 for any sample rate: a high shelf
+// for the head's acoustic effect and a high pass cutting the lows
+func kWeighting(sampleRate float64) (shelf, highPass biquad) {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// measures EBU R128 loudness of stereo audio. power is kept per 100ms so the
+// 400ms gating blocks of several songs can be pooled for album loudness.
+// mono comes with the same samples on both sides, so only the left one counts
+type loudnessMeter struct {
+	filters    [2][2]biquad // shelf and high pass per channel
+	segment    int          // samples in 100ms
+	sum        float64      // power of the current 100ms so far
+	n          int
+	segments   []float64 // mean power of every 100ms
+	peak       float64   // highest sample, 1 is full scale
+	sampleRate float64
+	channels   int
+}
+
+func newLoudnessMeter(sampleRate, channels int) *loudnessMeter {
+	m := &loudnessMeter{segment: sampleRate / 10, sampleRate: float64(sampleRate), channels: min(max(channels, 1), 2)}
+	for ch := range m.filters {
+		shelf, highPass := kWeighting(m.sampleRate)
+		m.filters[ch] = [2]biquad{shelf, highPass}
+	}
+	return m
+}
+
+func (m *loudnessMeter) add(samples [][2]float64) {
+	for _, s := range samples {
+		for ch, x := range s[:m.channels] {
+			m.peak = max(m.peak, math.Abs(x))
+			f := &m.filters[ch]
+			y := f[1].process(f[0].process(x))
+			m.sum += y * y
+		}
+		m.n++
+		if m.n == m.segment {
+			m.segments = append(m.segments, m.sum/float64(m.n))
+			m.sum, m.n = 0, 0
+		}
+	}
+}
+
+// the mean power of every 400ms block, overlapping by 75%
+func (m *loudnessMeter) blocks() []float64 {
+	var blocks []float64
+	for i := 3; i < len(m.segments); i++ {
+		blocks = append(blocks, (m.segments[i-3]+m.segments[i-2]+m.segments[i-1]+m.segments[i])/4)
+	}
+	return blocks
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// integrated loudness in LUFS of gating blocks: blocks under -70 LUFS are
+// silence, and blocks 10 LU under the loudness of the rest are left out too
+func integratedLoudness(blocks []float64) (float64, bool) {
+	mean := func(threshold float64) (float64, bool) {
+		var sum float64
+		var n int
+		for _, b := range blocks {
+			if blockLoudness(b) > threshold {
+				sum += b
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, false
+		}
+		return sum / float64(n), true
+	}
+
+	power, ok := mean(-70)
+	if !ok {
+		return 0, false
+	}
+	power, ok = mean(max(-70, blockLoudness(power)-10))
+	if !ok {
+		return 0, false
+	}
+	return blockLoudness(power), true
+}
+
+// what measuring a song found
+type loudness struct {
+	blocks []float64
+	peak   float64
+}
+
+// decode a song and measure it. only mp3 can be decoded, like for playback
+func measureLoudness(path string) (loudness, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".mp3" {
+		return loudness{}, errors.New("only mp3 files can be analysed")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return loudness{}, err
+	}
+	streamer, format, err := mp3.Decode(f)
+	if err != nil {
+		f.Close()
+		return loudness{}, fmt.Errorf("can't decode: %w", err)
+	}
+	defer streamer.Close()
+
+	meter := newLoudnessMeter(int(format.SampleRate), format.NumChannels)
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := streamer.Stream(buf)
+		meter.add(buf[:n])
+		if !ok {
+			break
+		}
+	}
+	if err := streamer.Err(); err != nil {
+		return loudness{}, err
+	}
+	return loudness{blocks: meter.blocks(), peak: meter.peak}, nil
+}
+
+// ReplayGain for the songs of an album, measured as one
+func albumGain(measured []loudness) (replayGain, bool) {
+	var blocks []float64
+	var peak float64
+	for _, l := range measured {
+		blocks = append(blocks, l.blocks...)
+		peak = max(peak, l.peak)
+	}
+	lufs, ok := integratedLoudness(blocks)
+	if !ok {
+		return replayGain{}, false
+	}
+	return replayGain{albumGain: replayGainReference - lufs, albumPeak: peak, hasAlbum: true}, true
+}
+
+// ReplayGain of a single song
+func trackGain(l loudness) (replayGain, bool) {
+	lufs, ok := integratedLoudness(l.blocks)
+	if !ok {
+		return replayGain{}, false
+	}
+	return replayGain{trackGain: replayGainReference - lufs, trackPeak: l.peak, hasTrack: true}, true
+}
@@ -7,21 +7,56 @@ import (
 
 	"github.com/0xAX/notificator"
 	tea "github.com/charmbracelet/bubbletea"
+	zone "github.com/lrstanley/bubblezone"
 )
 
 var (
 	musicDirFlag = flag.String("m", "", "set your music directory (the directory where all your musics are in)")
-	cfg          config
+	themeFlag    = flag.String("t", "", "set the theme (a built-in theme or a file in the themes directory)")
 	notify       *notificator.Notificator
 )
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: podden [flags] [analyze]")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if flag.Arg(0) == "analyze" {
+		os.Exit(runAnalyze(flag.Args()[1:]))
+	}
 	notify = notificator.New(notificator.Options{})
-	loadConfig(&cfg)
+	warnings, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error loading config:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	themeWarnings, err := loadTheme()
+	if err != nil {
+		fmt.Println("Error loading theme:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := loadPlayCounts(); err != nil {
+		warnings = append(warnings, "history.jsonl: "+err.Error())
+	}
+	if err := loadRatings(); err != nil {
+		warnings = append(warnings, "ratings.json: "+err.Error())
+	}
+	state, err := loadState()
+	if err != nil {
+		warnings = append(warnings, "state.json: "+err.Error())
+	}
+	applyVolume(state)
+	equalizer.restore(state)
 	initStyles()
+	zone.NewGlobal()
+
+	p := tea.NewProgram(initModel(append(warnings, themeWarnings...), state), tea.WithAltScreen(), tea.WithMouseCellMotion())
+	go watchConfig(p)
+	go watchLibrary(p)
 
-	p := tea.NewProgram(initModel(), tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		fmt.Println("Error running program:", err)
 		os.Exit(1)
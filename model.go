@@ -2,16 +2,18 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/blacktop/go-termimg"
 	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
 	"github.com/gopxl/beep"
-	"github.com/gopxl/beep/effects"
 	"github.com/gopxl/beep/speaker"
+	zone "github.com/lrstanley/bubblezone"
 )
 
 type model struct {
@@ -20,6 +22,19 @@ type model struct {
 	img         *termimg.ImageWidget
 	width       int
 	height      int
+	layout      layoutMode
+	page        page                  // what the list is showing
+	history     []navEntry            // lists to go back to
+	sorts       map[pageKind]sortMode // last sort order picked for each kind of page
+	search      textinput.Model
+	searching   bool       // typing into the search prompt
+	queue       []music    // songs to play before going on with the list
+	future      []navEntry // lists back left, for forward
+	status      string     // config problems and other notices
+	pendingKeys []string   // start of a key sequence like "g g"
+	scan        *scanner   // nil once the library scan is over
+	scanRead    int
+	scanFound   int
 	loaded      bool
 	showAlbums  bool // for albums page
 	showArtists bool // for artists page
@@ -29,20 +44,24 @@ type model struct {
 	currLyric   string
 	elapsed     time.Duration
 	total       time.Duration
+	playStarted time.Time     // zero once the play is in the history
+	listened    time.Duration // time heard of the current song
+	scrobbled   bool          // the current song has been queued for scrobbling
+	scrobbles   int           // listens waiting to be sent
 	currPlaying music
 	streamer    beep.StreamSeekCloser
-	volume      *effects.Volume
+	state       playerState // volume and mute, saved between runs
 	sampleRate  beep.SampleRate
 }
 
-func initModel() model {
+func initModel(warnings []string, state playerState) model {
 	help := help.New()
 
-	return model{loaded: false, playing: false, paused: false, help: help}
+	return model{loaded: false, playing: false, paused: false, help: help, layout: parseLayout(cfg().Layout), status: statusText(warnings), scan: newScanner(), search: newSearchInput(), state: state}
 }
 
 func (m model) Init() tea.Cmd {
-	return fetchMusics
+	return tea.Batch(m.scan.start, flushScrobblesCmd)
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -50,34 +69,69 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
+		m = m.resize()
 
 	case tea.KeyMsg:
+		// ctrl+c quits even while typing a search or filter, where other quit
+		// keys are just text
+		if msg.Type == tea.KeyCtrlC {
+			return m.recordPlay(false), tea.Quit
+		}
+		if m.searching {
+			return m.updateSearch(msg)
+		}
 		if m.list.FilterState() != list.Filtering {
-			switch msg.String() {
-			case "q", "ctrl+c":
-				return m, tea.Quit
+			var ok bool
+			pressed := msg.String()
+			if m, msg, ok = m.sequenceKey(msg); !ok {
+				return m, nil
+			}
+			// a finished sequence like "g g" stands for the whole binding
+			sequence := msg.String() != pressed
+
+			switch {
+			case key.Matches(msg, keys.Quit):
+				return m.recordPlay(false), tea.Quit
 
-			case "?":
+			case key.Matches(msg, keys.Help):
 				m.help.ShowAll = !m.help.ShowAll
-				return m, nil
+				return m.resize(), nil
 
-			case "enter":
-				// handle album selection
-				if m.showAlbums {
-					m = m.handleAlbumSelection()
-					return m, nil
-				}
-				// handle artist selection
-				if m.showArtists {
-					m = m.handleArtistSelection()
-					return m, nil
+			case key.Matches(msg, keys.StopScan):
+				if m.scan != nil {
+					m.scan.again = false
+					m.scan.stop()
 				}
-				// handle song selection and playback
-				if selected, ok := m.list.SelectedItem().(music); ok {
-					return m, func() tea.Msg { return playMusic(selected) }
+				return m, nil
+
+			case key.Matches(msg, keys.Problems):
+				m.playing = false
+				m.showAlbums = false
+				m.showArtists = false
+
+				return m, fetchProblems
+
+			case key.Matches(msg, keys.Retry):
+				return m.rescan()
+
+			case key.Matches(msg, keys.Layout):
+				m.layout = m.layout.next()
+				// the playing screen takes over the whole terminal outside wide
+				// layout, which brings the library pane back
+				switch {
+				case m.effectiveLayout() == layoutWide:
+					m = m.showList()
+				case m.playing:
+					m.loaded = false
+					m.showAlbums = false
+					m.showArtists = false
 				}
+				return m.resize(), nil
+
+			case key.Matches(msg, keys.Play):
+				return m.selectItem()
 
-			case " ":
+			case key.Matches(msg, keys.Pause):
 				if m.paused {
 					speaker.Unlock()
 					m.paused = false
@@ -87,117 +141,236 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					sendNotification(m.currPlaying, "paused")
 				}
 
-			case ">", "right":
-				samplesToJump := m.sampleRate.N(5 * time.Second)
-				m.streamer.Seek(m.sampleRate.N(m.elapsed) + samplesToJump)
+			case key.Matches(msg, keys.Forward):
+				m = m.seek(m.elapsed + 5*time.Second)
 
-			case "<", "left":
-				samplesToJump := m.sampleRate.N(5 * time.Second)
-				newPos := m.sampleRate.N(m.elapsed) - samplesToJump
-				if newPos < 0 {
-					newPos = 0
-				}
-				m.streamer.Seek(newPos)
+			case key.Matches(msg, keys.Rewind):
+				m = m.seek(m.elapsed - 5*time.Second)
 
-			case "n":
-				var cmd tea.Cmd
-				m.list, cmd = m.nextSong(m.list)
-				return m, cmd
+			case key.Matches(msg, keys.Next):
+				return m.playNext()
+
+			case key.Matches(msg, keys.Enqueue):
+				return m.enqueue(), nil
+
+			case key.Matches(msg, keys.Rate):
+				return m.rate(slices.Index(keys.Rate.Keys(), msg.String()))
+
+			case key.Matches(msg, keys.Favourite):
+				return m.toggleFavourite()
 
-			case "p":
+			case key.Matches(msg, keys.Stats):
+				m.playing = false
+				return m.showStats(), nil
+
+			case key.Matches(msg, keys.Sort):
+				return m.cycleSort()
+
+			case key.Matches(msg, keys.Search):
+				return m.startSearch()
+
+			case key.Matches(msg, keys.SaveSearch):
+				return m.savePlaylist(), nil
+
+			case key.Matches(msg, keys.Playlists):
+				m.playing = false
+				return m, fetchPlaylists
+
+			case key.Matches(msg, keys.Prev):
 				var cmd tea.Cmd
 				m.list, cmd = m.prevSong(m.list)
 				return m, cmd
 
-			case "s":
+			case key.Matches(msg, keys.Songs):
 				m.playing = false
 				m.showAlbums = false
 				m.showArtists = false
 
 				return m, func() tea.Msg { return fetchMusics() }
 
-			case "a":
+			case key.Matches(msg, keys.Albums):
 				m.playing = false
 				m.loaded = false
 				m.showArtists = false
 
 				return m, func() tea.Msg { return fetchAlbums() }
 
-			case "d":
+			case key.Matches(msg, keys.Artists):
 				m.playing = false
 				m.loaded = false
 				m.showAlbums = false
 
 				return m, func() tea.Msg { return fetchArtists() }
 
-			case "f":
+			// esc clears an applied filter before going back
+			case key.Matches(msg, keys.Back) && m.list.FilterState() == list.Unfiltered:
+				return m.back()
+
+			case key.Matches(msg, keys.ForwardPage):
+				return m.forward()
+
+			case key.Matches(msg, keys.Genres):
+				m.playing = false
+				return m, fetchGenres
+
+			case key.Matches(msg, keys.Years):
+				m.playing = false
+				return m, fetchDecades
+
+			case key.Matches(msg, keys.Playing):
+				// now playing is always on screen in wide layout
+				if m.effectiveLayout() == layoutWide {
+					return m, nil
+				}
 				m.playing = true
 				m.loaded = false
 				m.showArtists = false
 				m.showAlbums = false
 
-			case "+":
-				speaker.Lock()
-				m.volume.Volume += 0.5
-				speaker.Unlock()
+			case key.Matches(msg, keys.Increase):
+				return m.changeVolume(1), nil
+
+			case key.Matches(msg, keys.Decrease):
+				return m.changeVolume(-1), nil
 
-			case "-":
-				speaker.Lock()
-				m.volume.Volume -= 0.5
-				speaker.Unlock()
+			case key.Matches(msg, keys.Mute):
+				return m.toggleMute(), nil
+
+			case key.Matches(msg, keys.EQ):
+				m.playing = false
+				return m.showEQ(), nil
+
+			case key.Matches(msg, keys.EQUp) && m.page.kind == eqPage:
+				return m.adjustBand(1), nil
+
+			case key.Matches(msg, keys.EQDown) && m.page.kind == eqPage:
+				return m.adjustBand(-1), nil
+			}
+
+			// the list never gets the keys of a sequence, its last one may
+			// page or jump
+			if sequence {
+				return m, nil
 			}
 		}
 
-	case musicsMsg:
-		items := make([]list.Item, len(msg.musics))
-		for i, m := range msg.musics {
-			items[i] = m
+	case tea.MouseMsg:
+		return m.handleMouse(msg)
+
+	case scanMsg:
+		lib.add(msg.musics)
+		lib.addProblems(msg.problems)
+		m.scanRead, m.scanFound = msg.read, msg.found
+
+		var cmd tea.Cmd
+		if m.page.kind == noPage {
+			// show songs as soon as the first ones are read
+			if len(msg.musics) > 0 {
+				m = m.showSongs(lib.all())
+			}
+		} else if msg.done || time.Since(m.scan.refreshed) >= scanRefreshInterval {
+			m, cmd = m.refreshList()
+			m.scan.refreshed = time.Now()
 		}
-		l := list.New(items, customDelegate(), 30, 10)
-		l.Title = "Songs"
-		l.Styles = setCustomBubblesStyle()
 
-		m.list = l
-		m.loaded = true
+		if msg.done {
+			lib.markReady()
+			again := m.scan.again
+			m.scan = nil
+			if m.page.kind == noPage {
+				m = m.showSongs(nil)
+			}
+			if again {
+				var rescan tea.Cmd
+				m, rescan = m.rescan()
+				return m, tea.Batch(cmd, rescan)
+			}
+			if cfg().ReplayGain.Analyze {
+				cmd = tea.Batch(cmd, analyzeLibrary)
+			}
+			return m.resize(), cmd
+		}
+		return m.resize(), tea.Batch(cmd, m.scan.wait)
+
+	case musicsMsg:
+		m = m.switchPage(songsPage).showSongs(msg.musics)
+
+	case problemsMsg:
+		m = m.switchPage(problemsPage).showProblems(msg.problems)
+
+	case problemMsg:
+		lib.addProblems([]problem{msg.problem})
+		m.status = fmt.Sprintf("can't play %s: %s", msg.problem.Title(), msg.problem.reason)
+		m, cmd := m.refreshList()
+		return m.resize(), cmd
+
+	case errMsg:
+		m.status = msg.err.Error()
+		return m.resize(), nil
 
 	case albumsMsg:
 		items := make([]list.Item, len(msg.albums))
 		for i, a := range msg.albums {
 			items[i] = a
 		}
-		l := list.New(items, customDelegate(), 30, 10)
-		l.Title = "Albums"
-		l.Styles = setCustomBubblesStyle()
-
-		m.list = l
-		m.showAlbums = true
+		m = m.switchPage(albumsPage).setPage(page{kind: albumsPage, title: "Albums"}, items)
 
 	case artistsMsg:
 		items := make([]list.Item, len(msg.artists))
 		for i, a := range msg.artists {
 			items[i] = a
 		}
-		l := list.New(items, customDelegate(), 30, 10)
-		l.Title = "Artists"
-		l.Styles = setCustomBubblesStyle()
+		m = m.switchPage(artistsPage).setPage(page{kind: artistsPage, title: "Artists"}, items)
+
+	case playlistsMsg:
+		m = m.showPlaylists(msg.playlists)
+
+	case genresMsg:
+		items := make([]list.Item, len(msg.genres))
+		for i, g := range msg.genres {
+			items[i] = g
+		}
+		m = m.switchPage(genresPage).setPage(page{kind: genresPage, title: "Genres"}, items)
+
+	case decadesMsg:
+		items := make([]list.Item, len(msg.decades))
+		for i, d := range msg.decades {
+			items[i] = d
+		}
+		m = m.switchPage(yearsPage).setPage(page{kind: yearsPage, title: "Years"}, items)
 
-		m.list = l
-		m.showArtists = true
+	case libraryChangedMsg:
+		// the problems counter may have changed too
+		m, cmd := m.refreshList()
+		return m.resize(), cmd
 
 	case playingMsg:
-		m.loaded = false
+		// the previous song was cut short
+		m = m.recordPlay(false)
+		m.playStarted = time.Now()
+		// keep the library pane usable while playing in wide layout
+		if m.effectiveLayout() != layoutWide {
+			m.loaded = false
+		}
 		m.playing = true
 		m.currPlaying = msg.music
 		m.streamer = msg.streamer
-		m.volume = msg.volume
 		m.sampleRate = msg.sampleRate
 		m.lyrics = nil // Reset lyrics for the new song
 		m.currLyric = "♪"
 		m.paused = false
 		m.elapsed = 0
 		m.total = 0
+		m.scrobbled = false
+		return m, sendNowPlaying(msg.music)
 
 	case progressMsg:
+		// every song starts its own ticks, let the old ones die out
+		if msg.streamer != m.streamer {
+			return m, nil
+		}
+		// ticks stop while paused, so each one is a second heard
+		m.listened += time.Second
 		m.elapsed = msg.elapsed
 		m.total = msg.total
 
@@ -209,7 +382,24 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
-		return m, tickCmd(m.streamer, m.sampleRate)
+		m, cmd := m.checkScrobble()
+		return m, tea.Batch(tickCmd(m.streamer, m.sampleRate), cmd)
+
+	case analyzedMsg:
+		if msg.count == 0 {
+			return m, nil
+		}
+		m.status = fmt.Sprintf("measured the loudness of %s", trackCount(msg.count))
+		// list items carry the gain they were read with
+		m, cmd := m.refreshList()
+		return m.resize(), cmd
+
+	case scrobbleMsg:
+		m.scrobbles = msg.pending
+		if msg.err != nil && !isOffline(msg.err) {
+			m.status = "can't scrobble: " + msg.err.Error()
+		}
+		return m.resize(), nil
 
 	case lyricsMsg:
 		m.lyrics = msg.lyrics
@@ -218,9 +408,49 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case finishedMsg:
+		return m.recordPlay(true).playNext()
+
+	case configMsg:
+		library := cfg().Library
+		// keep the running config if the new one is broken
+		warnings, err := loadConfig()
+		if err != nil {
+			m.status = err.Error()
+			return m.resize(), nil
+		}
+		themeWarnings, err := loadTheme()
+		if err != nil {
+			m.status = err.Error()
+			return m.resize(), nil
+		}
+		m.status = statusText(append(warnings, themeWarnings...))
+		m.layout = parseLayout(cfg().Layout)
+		if m.streamer != nil {
+			m.withSpeaker(func() { setNormalizer(m.currPlaying) })
+		}
+		m = m.reloadEQ()
+
 		var cmd tea.Cmd
-		m.list, cmd = m.nextSong(m.list)
-		return m, cmd
+		if !library.sameFiles(cfg().Library) {
+			// the watcher drops what's no longer in the library and the
+			// rescan reads what's new
+			select {
+			case rewatchLibrary <- struct{}{}:
+			default:
+			}
+			m, cmd = m.rescan()
+		}
+		return m.restyle().resize(), cmd
+
+	case themeMsg:
+		// keep the current look if the file is half written or broken
+		warnings, err := loadTheme()
+		if err != nil {
+			m.status = err.Error()
+			return m.resize(), nil
+		}
+		m.status = statusText(warnings)
+		return m.restyle().resize(), nil
 
 	case coverMsg:
 		m.img = msg.img
@@ -238,37 +468,26 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) View() string {
-	if m.playing {
-		title := titleStyle.Render(m.currPlaying.title)
-		artist := artistStyle.Render(m.currPlaying.artist)
-		timeInfo := timeStyle.Render(fmt.Sprintf("%s / %s", m.elapsed, m.total))
-		lyric := lyricStyle.Render(m.currLyric)
-
-		mainContent := lipgloss.JoinVertical(
-			lipgloss.Left,
-			title,
-			artist,
-			"",
-			timeInfo,
-			"",
-			"",
-			lyric,
-		)
-		mainBox := screenStyle.Render(mainContent)
-
-		finalBox := mainBox
-
-		if m.img != nil {
-			cover, _ := m.img.Render()
-			finalBox = lipgloss.JoinHorizontal(0, mainBox, cover)
-		}
+	return zone.Scan(m.view())
+}
 
-		return finalBox
+func (m model) view() string {
+	if m.effectiveLayout() == layoutWide {
+		return m.center(m.wideView())
 	}
 
+	if m.playing {
+		return m.center(m.playingView())
+	}
+
+	width, height := m.screenSize()
 	if m.loaded || m.showAlbums || m.showArtists {
-		return m.center(screenStyle.Render(m.list.View()))
+		return m.center(renderScreen(zone.Mark(listZone, m.list.View()), width, height))
 	}
 
-	return m.center(screenStyle.Render("loading..."))
+	loading := "loading..."
+	if m.scan != nil {
+		loading = fmt.Sprintf("scanning library...\n\n%d/%d files", m.scanRead, m.scanFound)
+	}
+	return m.center(renderScreen(loading, width, height))
 }
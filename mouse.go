@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	zone "github.com/lrstanley/bubblezone"
+)
+
+// clickable zones
+const (
+	progressZone = "progress"
+	listZone     = "list"
+)
+
+func itemZone(index int) string {
+	return fmt.Sprintf("item-%d", index)
+}
+
+// wraps the list delegate so every item can be clicked
+type zoneDelegate struct {
+	list.DefaultDelegate
+}
+
+func (d zoneDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	var b strings.Builder
+	d.DefaultDelegate.Render(&b, m, index, item)
+	fmt.Fprint(w, zone.Mark(itemZone(index), b.String()))
+}
+
+func (m model) handleMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if msg.Action != tea.MouseActionPress {
+		return m, nil
+	}
+	listShown := (m.loaded || m.showAlbums || m.showArtists) && m.list.FilterState() != list.Filtering
+
+	switch msg.Button {
+	case tea.MouseButtonWheelUp, tea.MouseButtonWheelDown:
+		// scroll the list under the pointer, change volume anywhere else
+		if listShown && zone.Get(listZone).InBounds(msg) {
+			if msg.Button == tea.MouseButtonWheelUp {
+				m.list.CursorUp()
+			} else {
+				m.list.CursorDown()
+			}
+			return m, nil
+		}
+		if msg.Button == tea.MouseButtonWheelUp {
+			m = m.changeVolume(1)
+		} else {
+			m = m.changeVolume(-1)
+		}
+
+	case tea.MouseButtonLeft:
+		if z := zone.Get(progressZone); m.streamer != nil && z.InBounds(msg) {
+			x, _ := z.Pos(msg)
+			width := z.EndX - z.StartX + 1
+			return m.seek(time.Duration(float64(m.total) * float64(x) / float64(width))), nil
+		}
+
+		if listShown {
+			return m.clickItem(msg)
+		}
+	}
+
+	return m, nil
+}
+
+// select the clicked item, clicking the selected item opens or plays it
+func (m model) clickItem(msg tea.MouseMsg) (model, tea.Cmd) {
+	start, end := m.list.Paginator.GetSliceBounds(len(m.list.VisibleItems()))
+	for i := start; i < end; i++ {
+		if !zone.Get(itemZone(i)).InBounds(msg) {
+			continue
+		}
+		if i == m.list.Index() {
+			return m.selectItem()
+		}
+		m.list.Select(i)
+		break
+	}
+	return m, nil
+}
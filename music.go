@@ -1,14 +1,16 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,22 +18,36 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/dhowden/tag"
 	"github.com/gopxl/beep"
-	"github.com/gopxl/beep/effects"
 	"github.com/gopxl/beep/mp3"
 	"github.com/gopxl/beep/speaker"
 )
 
 type music struct {
-	title  string
-	artist string
-	path   string
-	album  string
-	cover  []byte
+	title       string
+	artist      string
+	path        string
+	album       string
+	albumArtist string
+	compilation bool // tagged as part of a various artists album
+	composer    string
+	genre       string
+	year        int
+	track       int // 0 when untagged
+	disc        int
+	duration    time.Duration
+	bitrate     int       // average kbit/s
+	format      string    // MP3, FLAC, M4A...
+	added       time.Time // when the file was last modified
+	tagRating   int       // stars from the file's own tags
+	gain        replayGain
+	cover       []byte
 }
 
 type album struct {
+	key    string // album artist and title
 	title  string
 	artist string
+	year   int
 	tracks []music
 }
 
@@ -51,15 +67,15 @@ type (
 )
 
 type progressMsg struct {
-	elapsed time.Duration
-	total   time.Duration
+	streamer beep.StreamSeekCloser // the song the tick is for
+	elapsed  time.Duration
+	total    time.Duration
 }
 
 type playingMsg struct {
 	music      music
 	streamer   beep.StreamSeekCloser
 	sampleRate beep.SampleRate
-	volume     *effects.Volume
 }
 
 type lrcLibResponse struct {
@@ -67,246 +83,314 @@ type lrcLibResponse struct {
 }
 
 // list.Item implementation
-func (s music) Title() string       { return s.title }
-func (s music) Description() string { return s.artist }
+func (s music) Title() string { return s.title }
+func (s music) Description() string {
+	return joinInfo(ratingText(lib.ratingOf(s)), s.artist, creditText("album by", s.albumArtist, s.artist),
+		creditText("composed by", s.composer, s.artist), s.genre, yearText(s.year), durationText(s.duration), formatText(s))
+}
 func (s music) FilterValue() string { return s.title }
 
-func (a album) Title() string       { return a.title }
-func (a album) Description() string { return a.artist }
+func (a album) Title() string { return a.title }
+func (a album) Description() string {
+	return joinInfo(a.artist, yearText(a.year), trackCount(len(a.tracks)))
+}
 func (a album) FilterValue() string { return a.title }
 
 func (a artist) Title() string       { return a.name }
-func (a artist) Description() string { return "" }
+func (a artist) Description() string { return trackCount(len(a.tracks)) }
 func (a artist) FilterValue() string { return a.name }
 
+// the non-empty parts of a description joined by dots
+func joinInfo(parts ...string) string {
+	var info []string
+	for _, p := range parts {
+		if p != "" {
+			info = append(info, p)
+		}
+	}
+	return strings.Join(info, " · ")
+}
+
+// "album by X" when someone other than the track artist is credited
+func creditText(role, name, artist string) string {
+	if name == "" || normalizeName(name) == normalizeName(artist) {
+		return ""
+	}
+	return role + " " + name
+}
+
+// "FLAC · 912 kbps"
+func formatText(s music) string {
+	bitrate := ""
+	if s.bitrate > 0 {
+		bitrate = fmt.Sprintf("%d kbps", s.bitrate)
+	}
+	return joinInfo(s.format, bitrate)
+}
+
+func yearText(year int) string {
+	if year == 0 {
+		return ""
+	}
+	return strconv.Itoa(year)
+}
+
+func trackCount(n int) string {
+	if n == 1 {
+		return "1 track"
+	}
+	return fmt.Sprintf("%d tracks", n)
+}
+
+func durationText(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return formatDuration(d)
+}
+
 func tickCmd(streamer beep.StreamSeekCloser, sr beep.SampleRate) tea.Cmd {
 	return tea.Tick(time.Second, func(time.Time) tea.Msg {
 		speaker.Lock()
 		elapsed := sr.D(streamer.Position()).Round(time.Second)
 		total := sr.D(streamer.Len()).Round(time.Second)
 		speaker.Unlock()
-		return progressMsg{elapsed, total}
+		return progressMsg{streamer, elapsed, total}
 	})
 }
 
-// global volume streamer
-var volume = &effects.Volume{
-	Base:   2,
-	Volume: 0,
-	Silent: false,
-}
-
-func fetchMusics() tea.Msg {
-	var musics []music
-	var dir string
-
-	// use default music dir
-	if *musicDirFlag == "" {
-		homeDir, _ := os.UserHomeDir()
-		dir = filepath.Join(homeDir, "Music")
-	} else {
-		dir = *musicDirFlag
+// read the tags of an audio file
+func readMusic(path string) (music, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return music{}, err
 	}
+	defer f.Close()
 
-	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
+	metadata, err := tag.ReadFrom(f)
+	if err != nil {
+		return music{}, err
+	}
 
-		// get common audio file
-		ext := filepath.Ext(path)
-		if ext != ".mp3" && ext != ".flac" && ext != ".m4a" {
-			return nil
-		}
+	title := metadata.Title()
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
 
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
-		}
-		defer f.Close()
+	artist := metadata.Artist()
+	if artist == "" {
+		artist = "Unknown Artist"
+	}
 
-		metadata, err := tag.ReadFrom(f)
-		if err != nil {
-			return nil
-		}
+	var cover []byte
+	if picture := metadata.Picture(); picture != nil {
+		cover = picture.Data
+	}
 
-		title := metadata.Title()
-		if title == "" {
-			title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-		}
+	track, _ := metadata.Track()
+	disc, _ := metadata.Disc()
+
+	m := music{
+		title:       title,
+		artist:      artist,
+		path:        path,
+		album:       metadata.Album(),
+		albumArtist: metadata.AlbumArtist(),
+		compilation: isCompilation(metadata.Raw()),
+		tagRating:   tagRating(metadata.Raw()),
+		gain:        readReplayGain(metadata.Raw()),
+		composer:    metadata.Composer(),
+		genre:       metadata.Genre(),
+		year:        metadata.Year(),
+		track:       track,
+		disc:        disc,
+		format:      string(metadata.FileType()),
+		cover:       cover,
+	}
 
-		artist := metadata.Artist()
-		if artist == "" {
-			artist = "Unknown Artist"
-		}
+	info, err := f.Stat()
+	if err != nil {
+		return music{}, err
+	}
+	m.added = info.ModTime()
+	// measurements from podden analyze only fill in what the tags lack
+	if rg, ok := cachedGain(path, info); ok {
+		m.gain = m.gain.fill(rg)
+	}
 
-		album := metadata.Album()
-		cover := metadata.Picture().Data
-
-		musics = append(musics, music{
-			title:  title,
-			artist: artist,
-			path:   path,
-			album:  album,
-			cover:  cover,
-		})
-		return nil
-	})
+	// a broken stream still has usable tags, so only the length is lost
+	if stream, err := probeStream(path, metadata.FileType()); err == nil && stream.duration > 0 {
+		m.duration = stream.duration
+		m.bitrate = int(float64(stream.size) * 8 / stream.duration.Seconds() / 1000)
+	}
+	return m, nil
+}
 
-	return musicsMsg{musics}
+func fetchMusics() tea.Msg {
+	return musicsMsg{lib.all()}
 }
 
 func fetchAlbums() tea.Msg {
-	albumsMap := make(map[string]album)
-	var dir string
+	return albumsMsg{groupAlbums(lib.all())}
+}
 
-	if *musicDirFlag == "" {
-		homeDir, _ := os.UserHomeDir()
-		dir = filepath.Join(homeDir, "Music")
-	} else {
-		dir = *musicDirFlag
-	}
+func fetchArtists() tea.Msg {
+	return artistsMsg{groupArtists(lib.all())}
+}
 
-	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
+const variousArtists = "Various Artists"
+
+// the iTunes compilation flag, TCMP in id3, cpil in mp4 and COMPILATION in
+// vorbis comments
+func isCompilation(raw map[string]any) bool {
+	for _, name := range []string{"TCMP", "TCP", "cpil", "compilation"} {
+		switch v := raw[name].(type) {
+		case int:
+			return v != 0
+		case string:
+			v = strings.TrimSpace(strings.TrimRight(v, "\x00"))
+			return v == "1" || strings.EqualFold(v, "true")
 		}
+	}
+	return false
+}
 
-		ext := filepath.Ext(path)
-		if ext != ".mp3" && ext != ".flac" && ext != ".m4a" {
-			return nil
-		}
+// fold case and whitespace so "Abbey Road" and "abbey  road " are one album
+func normalizeName(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
 
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
-		}
-		defer f.Close()
+// who an album is filed under: its album artist, Various Artists for
+// compilations, or nobody when untagged
+func albumArtistOf(m music) string {
+	switch {
+	case m.albumArtist != "":
+		return m.albumArtist
+	case m.compilation:
+		return variousArtists
+	}
+	return ""
+}
 
-		metadata, err := tag.ReadFrom(f)
-		if err != nil {
-			return nil
-		}
+// group tracks that have an album by album artist, or the track artist when
+// there is none, and album title
+func groupAlbums(musics []music) []album {
+	albumsMap := make(map[string]album)
+	var keys []string
 
+	for _, m := range musics {
 		// check if no album
-		if metadata.Album() == "" {
-			return nil
+		if m.album == "" {
+			continue
 		}
 
-		// create a key for map
-		albumKey := metadata.AlbumArtist() + " - " + metadata.Album()
-
-		currentMusic := music{
-			title:  metadata.Title(),
-			artist: metadata.Artist(),
-			path:   path,
-		}
+		// create a key for map, same titled albums by different artists stay
+		// apart when there's no album artist
+		artist := cmp.Or(albumArtistOf(m), m.artist)
+		albumKey := normalizeName(artist) + " - " + normalizeName(m.album)
 
 		// check if the album already exists in map
 		if existingAlbum, ok := albumsMap[albumKey]; ok {
-			existingAlbum.tracks = append(existingAlbum.tracks, currentMusic)
+			existingAlbum.tracks = append(existingAlbum.tracks, m)
+			if existingAlbum.year == 0 {
+				existingAlbum.year = m.year
+			}
 			albumsMap[albumKey] = existingAlbum
 		} else {
-			newAlbum := album{
-				title:  metadata.Album(),
-				artist: metadata.Artist(),
-				tracks: []music{currentMusic},
+			albumsMap[albumKey] = album{
+				key:    albumKey,
+				title:  m.album,
+				artist: artist,
+				year:   m.year,
+				tracks: []music{m},
 			}
-			albumsMap[albumKey] = newAlbum
+			keys = append(keys, albumKey)
 		}
-
-		return nil
-	})
+	}
 
 	// convert the map values into a slice of albums
-	var albums []album
-	for _, album := range albumsMap {
-		albums = append(albums, album)
+	albums := make([]album, len(keys))
+	for i, key := range keys {
+		a := albumsMap[key]
+		sortTracks(a.tracks)
+		albums[i] = a
 	}
-
-	return albumsMsg{albums}
+	return albums
 }
 
-func fetchArtists() tea.Msg {
-	artistsMap := make(map[string]artist)
-	var dir string
-
-	if *musicDirFlag == "" {
-		homeDir, _ := os.UserHomeDir()
-		dir = filepath.Join(homeDir, "Music")
-	} else {
-		dir = *musicDirFlag
-	}
-
-	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-
-		ext := filepath.Ext(path)
-		if ext != ".mp3" && ext != ".flac" && ext != ".m4a" {
-			return nil
-		}
-
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
+// order album tracks by disc and track number, untagged tracks after the
+// numbered ones in natural filename order
+func sortTracks(tracks []music) {
+	slices.SortStableFunc(tracks, func(a, b music) int {
+		if c := discOf(a) - discOf(b); c != 0 {
+			return c
 		}
-		defer f.Close()
-
-		metadata, err := tag.ReadFrom(f)
-		if err != nil {
-			return nil
+		switch {
+		case a.track > 0 && b.track > 0 && a.track != b.track:
+			return a.track - b.track
+		case a.track > 0 && b.track == 0:
+			return -1
+		case a.track == 0 && b.track > 0:
+			return 1
 		}
+		return naturalCompare(filepath.Base(a.path), filepath.Base(b.path))
+	})
+}
 
-		// create a key for map
-		artistKey := metadata.Artist()
+// single disc albums are often untagged, count them as disc 1
+func discOf(m music) int {
+	return max(m.disc, 1)
+}
 
-		currentMusic := music{
-			title:  metadata.Title(),
-			artist: metadata.Artist(),
-			path:   path,
-		}
+// group tracks by artist
+func groupArtists(musics []music) []artist {
+	artistsMap := make(map[string]artist)
+	var names []string
 
+	for _, m := range musics {
 		// check if the artist already exists in map
-		if existingArtist, ok := artistsMap[artistKey]; ok {
-			existingArtist.tracks = append(existingArtist.tracks, currentMusic)
-			artistsMap[artistKey] = existingArtist
+		if existingArtist, ok := artistsMap[m.artist]; ok {
+			existingArtist.tracks = append(existingArtist.tracks, m)
+			artistsMap[m.artist] = existingArtist
 		} else {
-			newArtist := artist{
-				name:   metadata.Artist(),
-				tracks: []music{currentMusic},
+			artistsMap[m.artist] = artist{
+				name:   m.artist,
+				tracks: []music{m},
 			}
-			artistsMap[artistKey] = newArtist
+			names = append(names, m.artist)
 		}
-
-		return nil
-	})
+	}
 
 	// convert the map values into a slice of artists
-	var artists []artist
-	for _, artist := range artistsMap {
-		artists = append(artists, artist)
+	artists := make([]artist, len(names))
+	for i, name := range names {
+		artists[i] = artistsMap[name]
 	}
-
-	return artistsMsg{artists}
+	return artists
 }
 
 func playMusic(m music) tea.Msg {
 	f, err := os.Open(m.path)
 	if err != nil {
-		return errMsg{err}
+		return problemMsg{newProblem(m.path, err)}
 	}
 
 	streamer, format, err := mp3.Decode(f)
 	if err != nil {
 		f.Close()
-		return errMsg{err}
+		return problemMsg{newProblem(m.path, fmt.Errorf("can't decode: %w", err))}
 	}
-	volume.Streamer = streamer
-
 	speaker.Clear()
 
+	// the ui changes these too, so rewire the chain with the speaker locked
+	speaker.Lock()
+	normalizer.Streamer = streamer
+	setNormalizer(m)
+	equalizer.Streamer = normalizer
+	equalizer.setSampleRate(format.SampleRate)
+	volume.Streamer = equalizer
+	speaker.Unlock()
+
 	speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10))
 
 	finishedMsgChan := make(chan tea.Msg, 1)
@@ -321,7 +405,7 @@ func playMusic(m music) tea.Msg {
 
 	return tea.Batch(
 		func() tea.Msg {
-			return playingMsg{music: m, streamer: streamer, volume: volume, sampleRate: format.SampleRate}
+			return playingMsg{music: m, streamer: streamer, sampleRate: format.SampleRate}
 		},
 		func() tea.Msg { return drawCover(m.cover) },
 		func() tea.Msg { return <-finishedMsgChan },
@@ -373,6 +457,11 @@ func fetchLyrics(title, artist string) tea.Msg {
 }
 
 func drawCover(data []byte) tea.Msg {
+	// songs without a cover
+	if len(data) == 0 {
+		return coverMsg{nil}
+	}
+
 	f, err := os.CreateTemp("", "cover-image")
 	if err != nil {
 		return errMsg{err}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// how many lists back can go
+const maxHistory = 50
+
+// a list to go back to, with its cursor, filter and scroll
+type navEntry struct {
+	list list.Model
+	page page
+}
+
+func (m model) currentEntry() navEntry {
+	return navEntry{list: m.list, page: m.page}
+}
+
+// remember the current list before leaving it for another one
+func (m model) pushHistory() model {
+	if m.page.kind == noPage {
+		return m
+	}
+	m.history = append(m.history, m.currentEntry())
+	if len(m.history) > maxHistory {
+		m.history = m.history[len(m.history)-maxHistory:]
+	}
+	// a new path drops the lists back came from
+	m.future = nil
+	return m
+}
+
+// leave the current list for a top level page, unless it's already showing
+func (m model) switchPage(kind pageKind) model {
+	if m.page.kind != kind {
+		m = m.pushHistory()
+	}
+	return m
+}
+
+// return to the previous list, or to the list the playing screen covers
+func (m model) back() (model, tea.Cmd) {
+	if m.playing && m.effectiveLayout() != layoutWide {
+		m.playing = false
+		return m.showList().resize(), nil
+	}
+	if len(m.history) == 0 {
+		return m, nil
+	}
+
+	m.future = append(m.future, m.currentEntry())
+	entry := m.history[len(m.history)-1]
+	m.history = m.history[:len(m.history)-1]
+	return m.restore(entry)
+}
+
+// undo the last back
+func (m model) forward() (model, tea.Cmd) {
+	if len(m.future) == 0 {
+		return m, nil
+	}
+
+	m.history = append(m.history, m.currentEntry())
+	entry := m.future[len(m.future)-1]
+	m.future = m.future[:len(m.future)-1]
+	return m.restore(entry)
+}
+
+// show a remembered list, rebuilt in case the library changed meanwhile
+func (m model) restore(entry navEntry) (model, tea.Cmd) {
+	m.list = entry.list
+	m.page = entry.page
+	m.playing = false
+
+	m, cmd := m.showList().resize().refreshList()
+	return m, cmd
+}
+
+// set the flags the views use to show the list of the current page
+func (m model) showList() model {
+	m.showAlbums = m.page.kind == albumsPage
+	m.showArtists = m.page.kind == artistsPage
+	m.loaded = !m.showAlbums && !m.showArtists && m.page.kind != noPage
+	return m
+}
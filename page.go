@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type pageKind int
+
+const (
+	noPage pageKind = iota // no list created yet
+	songsPage
+	albumsPage
+	artistsPage
+	albumPage        // tracks of one album
+	artistPage       // albums of one artist
+	artistTracksPage // every track of one artist
+	problemsPage     // files that couldn't be read
+	genresPage
+	genrePage       // albums of one genre
+	genreTracksPage // every track of one genre
+	yearsPage       // decades
+	decadePage      // albums of one decade
+	decadeTracksPage
+	searchPage        // results for the query in key
+	playlistsPage     // automatic and saved smart playlists
+	smartPlaylistPage // tracks matching the query in key
+	autoPlaylistPage  // the automatic playlist named in key
+	statsPage         // listening stats for the period in key
+	eqPage            // presets and bands of the equalizer
+)
+
+// what the list is showing, so it can be rebuilt when the library changes
+type page struct {
+	kind  pageKind
+	key   string // the album, artist, genre or decade the page is about
+	title string
+	sort  sortMode
+}
+
+// the page title with its sort order
+func (p page) listTitle() string {
+	if p.sort == sortNatural {
+		return p.title
+	}
+	return p.title + " · by " + p.sort.String()
+}
+
+// show items as the list of page p, in the order last picked for its kind
+func (m model) setPage(p page, items []list.Item) model {
+	p.sort = m.sorts[p.kind]
+	sortItems(items, p.sort)
+	m.list = m.newList(items, p.listTitle())
+	m.page = p
+	return m.showList()
+}
+
+// the items of a page, built from the library index
+func (p page) items() []list.Item {
+	var items []list.Item
+
+	switch p.kind {
+	case songsPage:
+		for _, m := range lib.all() {
+			items = append(items, m)
+		}
+
+	case albumsPage:
+		for _, a := range groupAlbums(lib.all()) {
+			items = append(items, a)
+		}
+
+	case artistsPage:
+		for _, a := range groupArtists(lib.all()) {
+			items = append(items, a)
+		}
+
+	case albumPage:
+		for _, a := range groupAlbums(lib.all()) {
+			if a.key == p.key {
+				items = albumItems(a)
+			}
+		}
+
+	case artistPage:
+		items = drillItems(page{kind: artistTracksPage, key: p.key}, byArtist(p.key))
+
+	case artistTracksPage:
+		items = matchingTracks(byArtist(p.key))
+
+	case genresPage:
+		for _, g := range groupGenres(lib.all()) {
+			items = append(items, g)
+		}
+
+	case genrePage:
+		items = drillItems(page{kind: genreTracksPage, key: p.key}, byGenre(p.key))
+
+	case genreTracksPage:
+		items = matchingTracks(byGenre(p.key))
+
+	case yearsPage:
+		for _, d := range groupDecades(lib.all()) {
+			items = append(items, d)
+		}
+
+	case decadePage:
+		items = drillItems(page{kind: decadeTracksPage, key: p.key}, byDecade(p.key))
+
+	case decadeTracksPage:
+		items = matchingTracks(byDecade(p.key))
+
+	case searchPage:
+		items = searchItems(p.key)
+
+	case playlistsPage:
+		for _, playlist := range autoPlaylists {
+			items = append(items, playlist)
+		}
+		f, _ := readPlaylists()
+		for _, playlist := range f.Smart {
+			items = append(items, playlist)
+		}
+
+	case smartPlaylistPage:
+		items = smartPlaylistItems(p.key)
+
+	case autoPlaylistPage:
+		items = autoPlaylistItems(p.key)
+
+	case statsPage:
+		items = statsItems(p.key)
+
+	case eqPage:
+		items = eqItems()
+
+	case problemsPage:
+		for _, problem := range lib.allProblems() {
+			items = append(items, problem)
+		}
+	}
+
+	sortItems(items, p.sort)
+	return items
+}
+
+// a separator in a list, like the discs of a multi-disc album
+type heading struct{ title string }
+
+func (h heading) Title() string       { return h.title }
+func (h heading) Description() string { return "" }
+func (h heading) FilterValue() string { return "" }
+
+// the tracks of an album, with a header before each disc if it has several
+func albumItems(a album) []list.Item {
+	multiDisc := slices.ContainsFunc(a.tracks, func(m music) bool {
+		return discOf(m) != discOf(a.tracks[0])
+	})
+
+	var items []list.Item
+	for i, m := range a.tracks {
+		if multiDisc && (i == 0 || discOf(m) != discOf(a.tracks[i-1])) {
+			items = append(items, heading{fmt.Sprintf("Disc %d", discOf(m))})
+		}
+		items = append(items, m)
+	}
+	return items
+}
+
+// the "All tracks" entry on top of an artist, genre or decade's albums
+type allTracks struct {
+	page  page // the page listing the tracks
+	count int
+}
+
+func (a allTracks) Title() string       { return "All tracks" }
+func (a allTracks) Description() string { return trackCount(a.count) }
+func (a allTracks) FilterValue() string { return "All tracks" }
+
+// an All tracks entry leading to tracksPage followed by every album with a
+// matching track, oldest first
+func drillItems(tracksPage page, match func(music) bool) []list.Item {
+	tracks := matchingTracks(match)
+	if len(tracks) == 0 {
+		return nil
+	}
+	items := []list.Item{allTracks{page: tracksPage, count: len(tracks)}}
+
+	var albums []album
+	for _, a := range groupAlbums(lib.all()) {
+		if slices.ContainsFunc(a.tracks, match) {
+			albums = append(albums, a)
+		}
+	}
+	// albums without a year go last
+	slices.SortStableFunc(albums, func(a, b album) int {
+		switch {
+		case a.year != b.year && a.year != 0 && b.year != 0:
+			return a.year - b.year
+		case a.year == 0 && b.year != 0:
+			return 1
+		case a.year != 0 && b.year == 0:
+			return -1
+		}
+		return naturalCompare(a.title, b.title)
+	})
+
+	for _, a := range albums {
+		items = append(items, a)
+	}
+	return items
+}
+
+// every track in the library that matches
+func matchingTracks(match func(music) bool) []list.Item {
+	var items []list.Item
+	for _, m := range lib.all() {
+		if match(m) {
+			items = append(items, m)
+		}
+	}
+	return items
+}
+
+// tracks by an artist, or on an album filed under them
+func byArtist(name string) func(music) bool {
+	return func(m music) bool { return m.artist == name || m.albumArtist == name }
+}
+
+// what identifies an item across rebuilds of a list
+func itemID(item list.Item) string {
+	switch item := item.(type) {
+	case music:
+		return item.path
+	case album:
+		return item.key
+	case artist:
+		return item.name
+	case problem:
+		return item.path
+	case heading:
+		return item.title
+	case allTracks:
+		return item.Title()
+	case genre:
+		return item.key
+	case decade:
+		return item.key()
+	case smartPlaylist:
+		return item.Name
+	case autoPlaylist:
+		return item.name
+	case statItem:
+		return item.title
+	case eqPreset:
+		return item.name
+	case eqBand:
+		return item.Title()
+	}
+	return ""
+}
+
+// rebuild the list from the library, keeping the cursor on the same item
+func (m model) refreshList() (model, tea.Cmd) {
+	if m.page.kind == noPage {
+		return m, nil
+	}
+
+	selected := itemID(m.list.SelectedItem())
+	index := m.list.Index()
+
+	items := m.page.items()
+	cmd := m.list.SetItems(items)
+
+	// a filtered list is refiltered by the command above
+	if m.list.FilterState() == list.Unfiltered {
+		for i, item := range items {
+			if itemID(item) == selected {
+				index = i
+				break
+			}
+		}
+		m.list.Select(min(index, max(len(items)-1, 0)))
+	}
+
+	return m, cmd
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"gopkg.in/yaml.v3"
+)
+
+// a saved query, evaluated again whenever it's opened or the library changes
+type smartPlaylist struct {
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"`
+}
+
+// playlists.yml next to the config
+type playlistsFile struct {
+	Smart []smartPlaylist `yaml:"smart"`
+}
+
+type playlistsMsg struct{ playlists []smartPlaylist }
+
+func (p smartPlaylist) Title() string       { return p.Name }
+func (p smartPlaylist) Description() string { return p.Query }
+func (p smartPlaylist) FilterValue() string { return p.Name }
+
+func playlistsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "playlists.yml"), nil
+}
+
+func readPlaylists() (playlistsFile, error) {
+	var f playlistsFile
+	path, err := playlistsPath()
+	if err != nil {
+		return f, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return f, err
+	}
+	_, err = decodeYaml(data, "playlists.yml", &f)
+	return f, err
+}
+
+func fetchPlaylists() tea.Msg {
+	f, err := readPlaylists()
+	if err != nil {
+		return errMsg{err}
+	}
+	return playlistsMsg{f.Smart}
+}
+
+// save a query as a smart playlist named after it, replacing one with the
+// same name
+func saveSmartPlaylist(q string) error {
+	if _, err := parseQuery(q); err != nil {
+		return err
+	}
+	f, err := readPlaylists()
+	if err != nil {
+		return err
+	}
+
+	f.Smart = slices.DeleteFunc(f.Smart, func(p smartPlaylist) bool { return p.Name == q })
+	f.Smart = append(f.Smart, smartPlaylist{Name: q, Query: q})
+
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	path, err := playlistsPath()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// save the query on the search page
+func (m model) savePlaylist() model {
+	if m.page.kind != searchPage || !isQuery(m.page.key) {
+		m.status = "only queries like artist:name can be saved as smart playlists"
+		return m.resize()
+	}
+	if err := saveSmartPlaylist(m.page.key); err != nil {
+		m.status = "can't save smart playlist: " + err.Error()
+		return m.resize()
+	}
+	m.status = fmt.Sprintf("saved smart playlist %q", m.page.key)
+	return m.resize()
+}
+
+// show the automatic and smart playlists as the Playlists page
+func (m model) showPlaylists(playlists []smartPlaylist) model {
+	var items []list.Item
+	for _, p := range autoPlaylists {
+		items = append(items, p)
+	}
+	for _, p := range playlists {
+		items = append(items, p)
+	}
+	return m.switchPage(playlistsPage).setPage(page{kind: playlistsPage, title: "Playlists"}, items)
+}
+
+// the tracks of a smart playlist
+func smartPlaylistItems(q string) []list.Item {
+	parsed, err := parseQuery(q)
+	if err != nil {
+		return []list.Item{heading{"invalid query: " + err.Error()}}
+	}
+	return matchingTracks(parsed.matches)
+}
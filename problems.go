@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// a file or directory that failed to scan or play
+type problem struct {
+	path   string
+	reason string
+}
+
+type (
+	problemsMsg struct{ problems []problem }
+	problemMsg  struct{ problem problem } // a song that failed to play
+)
+
+// list.Item implementation
+func (p problem) Title() string       { return filepath.Base(p.path) }
+func (p problem) Description() string { return p.reason }
+func (p problem) FilterValue() string { return p.path }
+
+func newProblem(path string, err error) problem {
+	return problem{path: path, reason: err.Error()}
+}
+
+func fetchProblems() tea.Msg {
+	return problemsMsg{lib.allProblems()}
+}
+
+// read a file that had a problem again
+func retryProblem(p problem) tea.Msg {
+	if m, err := readMusic(p.path); err == nil {
+		lib.set(m)
+	} else {
+		lib.addProblems([]problem{newProblem(p.path, err)})
+	}
+	return libraryChangedMsg{}
+}
+
+// scan the whole library again, forgetting the old problems. a running scan
+// may have started with other settings, so another one follows it
+func (m model) rescan() (model, tea.Cmd) {
+	if m.scan != nil {
+		m.scan.again = true
+		return m, nil
+	}
+	lib.clearProblems()
+	m.scan = newScanner()
+	m, cmd := m.refreshList()
+	return m.resize(), tea.Batch(cmd, m.scan.start)
+}
+
+// show problems as the Problems page
+func (m model) showProblems(problems []problem) model {
+	items := make([]list.Item, len(problems))
+	for i, p := range problems {
+		items[i] = p
+	}
+	return m.setPage(page{kind: problemsPage, title: "Problems"}, items)
+}
+
+// counter for the status line
+func problemsView(count int) string {
+	if count == 1 {
+		return fmt.Sprintf("1 file couldn't be read (%s to view, %s to retry)", keys.Problems.Help().Key, keys.Retry.Help().Key)
+	}
+	return fmt.Sprintf("%d files couldn't be read (%s to view, %s to retry)", count, keys.Problems.Help().Key, keys.Retry.Help().Key)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// text fields a query can match, with how to read them from a track
+var queryTextFields = map[string]func(music) string{
+	"title":       func(m music) string { return m.title },
+	"artist":      func(m music) string { return m.artist },
+	"album":       func(m music) string { return m.album },
+	"albumartist": func(m music) string { return albumArtistOf(m) },
+	"genre":       func(m music) string { return m.genre },
+	"composer":    func(m music) string { return m.composer },
+	"format":      func(m music) string { return m.format },
+	"path":        func(m music) string { return m.path },
+}
+
+// number fields, which also take ranges like 1998..2002, >5 or <3
+var queryNumberFields = map[string]func(music) int{
+	"year":     func(m music) int { return m.year },
+	"track":    func(m music) int { return m.track },
+	"disc":     func(m music) int { return m.disc },
+	"bitrate":  func(m music) int { return m.bitrate },
+	"duration": func(m music) int { return int(m.duration.Seconds()) },
+	"rating":   func(m music) int { return lib.ratingOf(m).Stars },
+	"plays":    func(m music) int { return lib.playCount(m.path) },
+}
+
+// a parsed query like `artist:"Boards of Canada" year:1998..2002 -title:remix`.
+// a track matches when it matches every term
+type query struct {
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	field  string // empty to match any text field
+	negate bool
+	text   string
+	lo, hi int // bounds of a number field, inclusive
+}
+
+// whether s uses the query language rather than plain fuzzy search, so a
+// colon in a song title doesn't turn a search into a broken query
+func isQuery(s string) bool {
+	for _, token := range splitQuery(s) {
+		field, _, ok := strings.Cut(strings.TrimPrefix(token, "-"), ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(field)
+		if _, text := queryTextFields[field]; text {
+			return true
+		}
+		if _, number := queryNumberFields[field]; number {
+			return true
+		}
+	}
+	return false
+}
+
+func parseQuery(s string) (query, error) {
+	var q query
+	for _, token := range splitQuery(s) {
+		var t queryTerm
+		if len(token) > 1 && token[0] == '-' {
+			t.negate = true
+			token = token[1:]
+		}
+
+		field, value, ok := strings.Cut(token, ":")
+		if !ok || strings.HasPrefix(field, `"`) {
+			t.text = strings.ToLower(unquote(token))
+			q.terms = append(q.terms, t)
+			continue
+		}
+
+		t.field = strings.ToLower(field)
+		value = unquote(value)
+		if _, ok := queryNumberFields[t.field]; ok {
+			lo, hi, err := parseRange(value)
+			if err != nil {
+				return q, fmt.Errorf("%s: %w", t.field, err)
+			}
+			t.lo, t.hi = lo, hi
+		} else if _, ok := queryTextFields[t.field]; ok {
+			t.text = strings.ToLower(value)
+		} else {
+			return q, fmt.Errorf("unknown field %q, use one of: %s", field, strings.Join(queryFieldNames(), ", "))
+		}
+		q.terms = append(q.terms, t)
+	}
+	return q, nil
+}
+
+func (q query) matches(m music) bool {
+	for _, t := range q.terms {
+		if t.matches(m) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t queryTerm) matches(m music) bool {
+	if number, ok := queryNumberFields[t.field]; ok {
+		n := number(m)
+		return n >= t.lo && n <= t.hi
+	}
+	if text, ok := queryTextFields[t.field]; ok {
+		return strings.Contains(strings.ToLower(text(m)), t.text)
+	}
+
+	// a bare word matches any text field
+	for _, text := range queryTextFields {
+		if strings.Contains(strings.ToLower(text(m)), t.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// split on spaces outside of double quotes
+func splitQuery(s string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func unquote(s string) string {
+	return strings.ReplaceAll(s, `"`, "")
+}
+
+// parse 1998, 1998..2002, 1998.., ..2002, >1998 or <2002. durations can be
+// written as 3:30
+func parseRange(s string) (int, int, error) {
+	if s == "" {
+		return 0, 0, errors.New("missing value")
+	}
+	switch s[0] {
+	case '>':
+		n, err := parseNumber(s[1:])
+		return n + 1, math.MaxInt, err
+	case '<':
+		n, err := parseNumber(s[1:])
+		return math.MinInt, n - 1, err
+	}
+
+	from, to, ok := strings.Cut(s, "..")
+	if !ok {
+		n, err := parseNumber(s)
+		return n, n, err
+	}
+
+	lo, hi := math.MinInt, math.MaxInt
+	var err error
+	if from != "" {
+		if lo, err = parseNumber(from); err != nil {
+			return 0, 0, err
+		}
+	}
+	if to != "" {
+		if hi, err = parseNumber(to); err != nil {
+			return 0, 0, err
+		}
+	}
+	return lo, hi, nil
+}
+
+func parseNumber(s string) (int, error) {
+	if minutes, seconds, ok := strings.Cut(s, ":"); ok {
+		m, err1 := strconv.Atoi(minutes)
+		sec, err2 := strconv.Atoi(seconds)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		return m*60 + sec, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func queryFieldNames() []string {
+	var names []string
+	for name := range queryTextFields {
+		names = append(names, name)
+	}
+	for name := range queryNumberFields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// every track matching a query, grouped like fuzzy search results
+func queryItems(s string) []list.Item {
+	q, err := parseQuery(s)
+	if err != nil {
+		return []list.Item{heading{"invalid query: " + err.Error()}}
+	}
+	tracks := matchingTracks(q.matches)
+	return appendResults(nil, "Songs", tracks)
+}
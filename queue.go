@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// the songs an item stands for, so albums and artists can be queued whole
+func itemTracks(item list.Item) []music {
+	switch item := item.(type) {
+	case music:
+		return []music{item}
+	case album:
+		return item.tracks
+	case artist:
+		return item.tracks
+	case genre:
+		return item.tracks
+	case decade:
+		return item.tracks
+	case allTracks:
+		return pageTracks(item.page)
+	case smartPlaylist:
+		return pageTracks(page{kind: smartPlaylistPage, key: item.Query})
+	case autoPlaylist:
+		return pageTracks(page{kind: autoPlaylistPage, key: item.name})
+	}
+	return nil
+}
+
+// the songs listed on a page
+func pageTracks(p page) []music {
+	var tracks []music
+	for _, i := range p.items() {
+		if m, ok := i.(music); ok {
+			tracks = append(tracks, m)
+		}
+	}
+	return tracks
+}
+
+// add the selected item to the end of the queue
+func (m model) enqueue() model {
+	m.queue = append(m.queue, itemTracks(m.list.SelectedItem())...)
+	return m.resize()
+}
+
+// play the first queued song, or the next one in the list
+func (m model) playNext() (model, tea.Cmd) {
+	if len(m.queue) == 0 {
+		var cmd tea.Cmd
+		m.list, cmd = m.nextSong(m.list)
+		return m, cmd
+	}
+
+	next := m.queue[0]
+	m.queue = m.queue[1:]
+	return m.resize(), func() tea.Msg { return playMusic(next) }
+}
+
+// counter for the status line
+func queueView(count int) string {
+	if count == 1 {
+		return "1 song queued"
+	}
+	return fmt.Sprintf("%d songs queued", count)
+}
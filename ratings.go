@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// the most stars a track can get, the rate keys stand for 0 up to it
+const maxStars = 5
+
+// the stars and favourite mark of a track
+type rating struct {
+	Stars     int  `json:"stars,omitempty"` // 1 to 5, 0 when unrated
+	Favourite bool `json:"favourite,omitempty"`
+}
+
+func ratingsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ratings.json"), nil
+}
+
+// read ratings.json into the library index
+func loadRatings() error {
+	path, err := ratingsPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ratings map[string]rating
+	if err := json.Unmarshal(data, &ratings); err != nil {
+		return err
+	}
+	for path, r := range ratings {
+		lib.setRating(path, r)
+	}
+	return nil
+}
+
+// write every rating to ratings.json
+func saveRatings() error {
+	path, err := ratingsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(lib.allRatings(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// the rating stars read from a file's POPM frame or FMPS_RATING comment
+func tagRating(raw map[string]any) int {
+	for _, name := range []string{"POPM", "POP"} {
+		if body, ok := raw[name].([]byte); ok {
+			return popmStars(body)
+		}
+	}
+	if value, ok := raw["fmps_rating"].(string); ok {
+		return fmpsStars(value)
+	}
+	return 0
+}
+
+// ♥ ★★★ for descriptions, empty when unrated
+func ratingText(r rating) string {
+	var parts []string
+	if r.Favourite {
+		parts = append(parts, "♥")
+	}
+	if r.Stars > 0 {
+		parts = append(parts, strings.Repeat("★", r.Stars))
+	}
+	return strings.Join(parts, " ")
+}
+
+// the song rating keys apply to, the one playing on the playing screen or
+// the selected one in lists
+func (m model) ratingTarget() (music, bool) {
+	if m.playing {
+		return m.currPlaying, m.currPlaying.path != ""
+	}
+	selected, ok := m.list.SelectedItem().(music)
+	return selected, ok
+}
+
+// give the target song 0 to 5 stars
+func (m model) rate(stars int) (model, tea.Cmd) {
+	target, ok := m.ratingTarget()
+	if !ok {
+		return m, nil
+	}
+	stars = min(max(stars, 0), maxStars)
+	r := lib.ratingOf(target)
+	r.Stars = stars
+	lib.setRating(target.path, r)
+
+	m, cmd := m.ratingChanged()
+	if !cfg().Library.WriteRatings {
+		return m, cmd
+	}
+	write := func() tea.Msg {
+		if err := writeRatingTag(target.path, stars); err != nil {
+			return errMsg{fmt.Errorf("can't write rating to %s: %w", filepath.Base(target.path), err)}
+		}
+		return nil
+	}
+	return m, tea.Batch(cmd, write)
+}
+
+// mark or unmark the target song as a favourite
+func (m model) toggleFavourite() (model, tea.Cmd) {
+	target, ok := m.ratingTarget()
+	if !ok {
+		return m, nil
+	}
+	r := lib.ratingOf(target)
+	r.Favourite = !r.Favourite
+	lib.setRating(target.path, r)
+	return m.ratingChanged()
+}
+
+// save ratings and redraw the list so descriptions show the new ones
+func (m model) ratingChanged() (model, tea.Cmd) {
+	if err := saveRatings(); err != nil {
+		m.status = "can't save ratings: " + err.Error()
+	}
+	m, cmd := m.refreshList()
+	return m.resize(), cmd
+}
+
+// lists the Playlists page builds from ratings, play counts and dates
+type autoPlaylist struct {
+	name string
+	desc string
+}
+
+func (p autoPlaylist) Title() string       { return p.name }
+func (p autoPlaylist) Description() string { return p.desc }
+func (p autoPlaylist) FilterValue() string { return p.name }
+
+// how many songs the top and recent lists hold
+const autoPlaylistSize = 25
+
+var autoPlaylists = []autoPlaylist{
+	{"Favourites", "songs marked with ♥"},
+	{"Top 25 Most Played", "the songs played most"},
+	{"Recently Added", "the newest songs in the library"},
+	{"Never Played", "songs without a single play"},
+}
+
+// the tracks of an automatic playlist, in the playlist's own order
+func autoPlaylistItems(name string) []list.Item {
+	tracks := lib.all()
+	switch name {
+	case "Favourites":
+		tracks = slices.DeleteFunc(tracks, func(m music) bool { return !lib.ratingOf(m).Favourite })
+
+	case "Top 25 Most Played":
+		tracks = slices.DeleteFunc(tracks, func(m music) bool { return lib.playCount(m.path) == 0 })
+		slices.SortStableFunc(tracks, func(a, b music) int {
+			return lib.playCount(b.path) - lib.playCount(a.path)
+		})
+		tracks = tracks[:min(len(tracks), autoPlaylistSize)]
+
+	case "Recently Added":
+		slices.SortStableFunc(tracks, func(a, b music) int { return b.added.Compare(a.added) })
+		tracks = tracks[:min(len(tracks), autoPlaylistSize)]
+
+	case "Never Played":
+		tracks = slices.DeleteFunc(tracks, func(m music) bool { return lib.playCount(m.path) > 0 })
+
+	default:
+		return nil
+	}
+
+	items := make([]list.Item, len(tracks))
+	for i, t := range tracks {
+		items[i] = t
+	}
+	return items
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+	"github.com/gopxl/beep/effects"
+)
+
+// the replaygain section of the config
+type replayGainConfig struct {
+	Mode            string  `yaml:"mode"`   // track, album or off
+	Preamp          float64 `yaml:"preamp"` // dB added to every gain
+	PreventClipping bool    `yaml:"prevent_clipping"`
+	Analyze         bool    `yaml:"analyze"` // measure untagged songs in the background
+}
+
+// loudness adjustments from a file's tags, gains in dB and peaks as sample
+// amplitudes where 1 is full scale
+type replayGain struct {
+	trackGain, trackPeak float64
+	albumGain, albumPeak float64
+	hasTrack, hasAlbum   bool
+	unmeasurable         bool // podden analyze couldn't measure this version of the file
+}
+
+// R128 gains are relative to -23 LUFS, ReplayGain ones to about -18
+const r128Offset = 5
+
+// global normalisation streamer, fed by the song and feeding the volume
+var normalizer = &effects.Gain{}
+
+// read ReplayGain and R128 tags. names are matched without case since every
+// tagger writes them differently
+func readReplayGain(raw map[string]any) replayGain {
+	values := make(map[string]string)
+	for name, v := range raw {
+		switch v := v.(type) {
+		case *tag.Comm:
+			// id3 TXXX frames keep the name in the description
+			values[strings.ToLower(v.Description)] = v.Text
+		case string:
+			values[strings.ToLower(name)] = v
+		case []string:
+			if len(v) > 0 {
+				values[strings.ToLower(name)] = v[0]
+			}
+		}
+	}
+
+	var rg replayGain
+	if gain, ok := parseGain(values["replaygain_track_gain"]); ok {
+		rg.trackGain, rg.hasTrack = gain, true
+	} else if gain, ok := parseR128(values["r128_track_gain"]); ok {
+		rg.trackGain, rg.hasTrack = gain, true
+	}
+	if gain, ok := parseGain(values["replaygain_album_gain"]); ok {
+		rg.albumGain, rg.hasAlbum = gain, true
+	} else if gain, ok := parseR128(values["r128_album_gain"]); ok {
+		rg.albumGain, rg.hasAlbum = gain, true
+	}
+	rg.trackPeak, _ = parseGain(values["replaygain_track_peak"])
+	rg.albumPeak, _ = parseGain(values["replaygain_album_peak"])
+	return rg
+}
+
+// rg with the gains it lacks taken from other, so tags keep winning over
+// measurements
+func (rg replayGain) fill(other replayGain) replayGain {
+	if !rg.hasTrack && other.hasTrack {
+		rg.trackGain, rg.trackPeak, rg.hasTrack = other.trackGain, other.trackPeak, true
+	}
+	if !rg.hasAlbum && other.hasAlbum {
+		rg.albumGain, rg.albumPeak, rg.hasAlbum = other.albumGain, other.albumPeak, true
+	}
+	rg.unmeasurable = rg.unmeasurable || other.unmeasurable
+	return rg
+}
+
+// "-6.54 dB" or a plain number
+func parseGain(s string) (float64, bool) {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(s, "dB"), "db"))
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// R128 gains are whole numbers in 1/256 dB
+func parseR128(s string) (float64, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimRight(s, "\x00")))
+	if err != nil {
+		return 0, false
+	}
+	return float64(n)/256 + r128Offset, true
+}
+
+// the amplitude factor for a song under the configured mode. the preamp
+// applies to tagged songs only, untagged ones play as they are
+func gainFactor(m music) float64 {
+	c := cfg().ReplayGain
+	gain, peak, ok := m.gain.pick(c.Mode)
+	if !ok {
+		return 1
+	}
+	factor := math.Pow(10, (gain+c.Preamp)/20)
+	if c.PreventClipping && peak > 0 && factor*peak > 1 {
+		factor = 1 / peak
+	}
+	return factor
+}
+
+// the gain and peak to use for a mode, falling back to the other kind when
+// a song only has one
+func (rg replayGain) pick(mode string) (gain, peak float64, ok bool) {
+	switch {
+	case mode != "track" && mode != "album":
+		return 0, 0, false
+	case mode == "album" && rg.hasAlbum, !rg.hasTrack && rg.hasAlbum:
+		return rg.albumGain, rg.albumPeak, true
+	case rg.hasTrack:
+		return rg.trackGain, rg.trackPeak, true
+	}
+	return 0, 0, false
+}
+
+// point the normaliser at the gain of a song, call with the speaker locked
+// while it plays
+func setNormalizer(m music) {
+	normalizer.Gain = gainFactor(m) - 1
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// files opened at once, tag reading mostly waits on the disk or network
+const scanWorkers = 16
+
+// how often the scan hands what it read so far to the ui
+const scanBatchInterval = 100 * time.Millisecond
+
+// how often the open list is rebuilt with new tracks during a scan, sorting
+// and grouping the whole library every batch would slow big scans down
+const scanRefreshInterval = time.Second
+
+// tracks read by the scan since the last message and how far along it is
+type scanMsg struct {
+	musics   []music
+	problems []problem
+	read     int // files read so far
+	found    int // audio files found so far
+	done     bool
+}
+
+// a library scan running in the background
+type scanner struct {
+	updates   chan scanMsg
+	ctx       context.Context
+	cancel    context.CancelFunc
+	refreshed time.Time // when the ui last rebuilt the list with new tracks
+	again     bool      // scan once more when done, the settings changed meanwhile
+}
+
+func newScanner() *scanner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scanner{updates: make(chan scanMsg), ctx: ctx, cancel: cancel}
+}
+
+// start the scan and wait for its first batch
+func (s *scanner) start() tea.Msg {
+	go s.run()
+	return s.wait()
+}
+
+// wait for the next batch
+func (s *scanner) wait() tea.Msg {
+	return <-s.updates
+}
+
+// stop the scan, the tracks read so far are kept
+func (s *scanner) stop() {
+	s.cancel()
+}
+
+func (s *scanner) run() {
+	var found, read atomic.Int64
+	paths := make(chan string)
+	results := make(chan music)
+	failures := make(chan problem)
+
+	report := func(path string, err error) {
+		select {
+		case failures <- newProblem(path, err):
+		case <-s.ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(paths)
+		walkLibrary(s.ctx, func(path string) {
+			found.Add(1)
+			select {
+			case paths <- path:
+			case <-s.ctx.Done():
+			}
+		}, report)
+	}()
+
+	var wg sync.WaitGroup
+	for range scanWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				m, err := readMusic(path)
+				read.Add(1)
+				if err != nil {
+					report(path, err)
+					continue
+				}
+				select {
+				case results <- m:
+				case <-s.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	ticker := time.NewTicker(scanBatchInterval)
+	defer ticker.Stop()
+
+	var batch []music
+	var problems []problem
+	var lastRead int
+	flush := func(done bool) {
+		msg := scanMsg{musics: batch, problems: problems, read: int(read.Load()), found: int(found.Load()), done: done}
+		if !done && len(batch) == 0 && len(problems) == 0 && msg.read == lastRead {
+			return
+		}
+		s.updates <- msg
+		batch, problems, lastRead = nil, nil, msg.read
+	}
+
+	for {
+		select {
+		case m, ok := <-results:
+			if !ok {
+				flush(true)
+				return
+			}
+			batch = append(batch, m)
+		case p := <-failures:
+			problems = append(problems, p)
+		case <-ticker.C:
+			flush(false)
+		}
+	}
+}
+
+// progress of a running scan
+func (m model) scanView() string {
+	return fmt.Sprintf("scanning library... %d/%d files (%s to stop)", m.scanRead, m.scanFound, keys.StopScan.Help().Key)
+}
@@ -0,0 +1,551 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// the scrobble section of the config, a service is used once its
+// credentials are set
+type scrobbleConfig struct {
+	ListenBrainz listenBrainzConfig `yaml:"listenbrainz"`
+	LastFM       lastFMConfig       `yaml:"lastfm"`
+}
+
+type listenBrainzConfig struct {
+	Token string `yaml:"token"`
+	URL   string `yaml:"url"`
+}
+
+type lastFMConfig struct {
+	APIKey     string `yaml:"api_key"`
+	Secret     string `yaml:"secret"`
+	SessionKey string `yaml:"session_key"`
+	URL        string `yaml:"url"` // any Last.fm compatible api, like Libre.fm
+}
+
+const (
+	listenBrainzURL = "https://api.listenbrainz.org"
+	lastFMURL       = "https://ws.audioscrobbler.com/2.0/"
+)
+
+// songs shorter than this are never scrobbled
+const minScrobbleLength = 30 * time.Second
+
+// a song is scrobbled once half of it or this much has been heard
+const maxScrobbleWait = 4 * time.Minute
+
+// a song heard long enough to count
+type listen struct {
+	Artist   string    `json:"artist"`
+	Title    string    `json:"title"`
+	Album    string    `json:"album,omitempty"`
+	Duration float64   `json:"duration,omitempty"` // seconds
+	Started  time.Time `json:"started"`
+}
+
+// a listen waiting in the queue for one service
+type queuedListen struct {
+	Service string `json:"service"`
+	listen
+}
+
+// what a service needs to take scrobbles
+type scrobbler interface {
+	name() string
+	nowPlaying(l listen) error
+	submit(listens []listen) error
+	batchSize() int // most listens a single submit takes
+}
+
+var scrobbleClient = &http.Client{Timeout: 15 * time.Second}
+
+// the services with credentials in the config
+func scrobblers() []scrobbler {
+	var s []scrobbler
+	conf := cfg().Scrobble
+	if c := conf.ListenBrainz; c.Token != "" {
+		s = append(s, listenBrainz{c})
+	}
+	if c := conf.LastFM; c.APIKey != "" && c.Secret != "" && c.SessionKey != "" {
+		s = append(s, lastFM{c})
+	}
+	return s
+}
+
+func listenOf(m music, started time.Time) listen {
+	return listen{
+		Artist:   m.artist,
+		Title:    m.title,
+		Album:    m.album,
+		Duration: m.duration.Seconds(),
+		Started:  started,
+	}
+}
+
+// whether a song has been heard long enough to scrobble, half of it or four
+// minutes, whichever comes first
+func shouldScrobble(listened, total time.Duration) bool {
+	if total < minScrobbleLength {
+		return false
+	}
+	return heardEnough(listened, total)
+}
+
+// half of a song or four minutes of it, whichever comes first
+func heardEnough(listened, total time.Duration) bool {
+	return listened >= min(total/2, maxScrobbleWait)
+}
+
+type scrobbleMsg struct {
+	pending int // listens still waiting in the queue
+	err     error
+}
+
+// tell every service what's playing. it's only a hint, so failures are
+// dropped rather than queued
+func sendNowPlaying(m music) tea.Cmd {
+	services := scrobblers()
+	if len(services) == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		l := listenOf(m, time.Now())
+		for _, s := range services {
+			s.nowPlaying(l)
+		}
+		return nil
+	}
+}
+
+// queue a listen for every service and try to send the queue
+func scrobble(l listen) tea.Cmd {
+	services := scrobblers()
+	if len(services) == 0 {
+		return nil
+	}
+	return func() tea.Msg {
+		var queued []queuedListen
+		for _, s := range services {
+			queued = append(queued, queuedListen{s.name(), l})
+		}
+		if err := appendScrobbles(queued); err != nil {
+			return scrobbleMsg{err: err}
+		}
+		return flushScrobbles()
+	}
+}
+
+// try to send the listens queued while offline
+func flushScrobblesCmd() tea.Msg {
+	if len(scrobblers()) == 0 {
+		return nil
+	}
+	return flushScrobbles()
+}
+
+// the queue file is rewritten by flushes, one at a time
+var scrobbleMu sync.Mutex
+
+func scrobbleQueuePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scrobbles.jsonl"), nil
+}
+
+// listens a service turned down are kept here instead of blocking the queue
+func rejectedScrobblesPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scrobbles-rejected.jsonl"), nil
+}
+
+// a service refused the listens themselves, sending them again won't help
+var errRejected = errors.New("rejected")
+
+// add listens to the queue on disk before anything is sent, so a crash or a
+// dropped connection never loses one
+func appendScrobbles(queued []queuedListen) error {
+	scrobbleMu.Lock()
+	defer scrobbleMu.Unlock()
+
+	path, err := scrobbleQueuePath()
+	if err != nil {
+		return err
+	}
+	return appendListens(path, queued)
+}
+
+func appendListens(path string, queued []queuedListen) error {
+	var data []byte
+	for _, q := range queued {
+		line, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readScrobbles(path string) ([]queuedListen, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queued []queuedListen
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var q queuedListen
+		if json.Unmarshal(scanner.Bytes(), &q) == nil && q.Service != "" {
+			queued = append(queued, q)
+		}
+	}
+	return queued, scanner.Err()
+}
+
+func writeScrobbles(path string, queued []queuedListen) error {
+	if len(queued) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var data []byte
+	for _, q := range queued {
+		line, err := json.Marshal(q)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// send queued listens in batches, oldest first, keeping whatever a service
+// couldn't take. listens it rejected are moved out of the queue, and listens
+// for services no longer configured stay queued
+func flushScrobbles() scrobbleMsg {
+	scrobbleMu.Lock()
+	defer scrobbleMu.Unlock()
+
+	path, err := scrobbleQueuePath()
+	if err != nil {
+		return scrobbleMsg{err: err}
+	}
+	queued, err := readScrobbles(path)
+	if err != nil {
+		return scrobbleMsg{err: err}
+	}
+
+	var flushErr error
+	var rejected []queuedListen
+	for _, s := range scrobblers() {
+		var listens []listen
+		for _, q := range queued {
+			if q.Service == s.name() {
+				listens = append(listens, q.listen)
+			}
+		}
+
+		// listens sent or rejected, from the front of the queue
+		done := 0
+		size := s.batchSize()
+	send:
+		for done < len(listens) {
+			batch := listens[done:min(len(listens), done+size)]
+			err := s.submit(batch)
+			switch {
+			case errors.Is(err, errRejected) && len(batch) > 1:
+				// a batch is rejected as a whole, so send the rest one at a
+				// time to find the listens at fault
+				size = 1
+				continue
+			case errors.Is(err, errRejected):
+				rejected = append(rejected, queuedListen{s.name(), batch[0]})
+				flushErr = errors.Join(flushErr, fmt.Errorf("%s: %w", s.name(), err))
+			case err != nil:
+				// offline or a problem on the service's side, try again later
+				flushErr = errors.Join(flushErr, fmt.Errorf("%s: %w", s.name(), err))
+				break send
+			}
+			done += len(batch)
+		}
+
+		queued = slices.DeleteFunc(queued, func(q queuedListen) bool {
+			if q.Service == s.name() && done > 0 {
+				done--
+				return true
+			}
+			return false
+		})
+	}
+
+	if len(rejected) > 0 {
+		rejectedPath, err := rejectedScrobblesPath()
+		if err == nil {
+			err = appendListens(rejectedPath, rejected)
+		}
+		if err != nil {
+			// keep them queued rather than lose them
+			queued = append(rejected, queued...)
+			flushErr = errors.Join(flushErr, err)
+		}
+	}
+
+	if err := writeScrobbles(path, queued); err != nil {
+		flushErr = errors.Join(flushErr, err)
+	}
+	return scrobbleMsg{pending: len(queued), err: flushErr}
+}
+
+// being offline is expected, the queue takes care of it
+func isOffline(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// counter for the status line
+func scrobbleView(pending int) string {
+	if pending == 1 {
+		return "1 scrobble waiting to be sent"
+	}
+	return fmt.Sprintf("%d scrobbles waiting to be sent", pending)
+}
+
+// send the current song once it's been heard long enough
+func (m model) checkScrobble() (model, tea.Cmd) {
+	if m.scrobbled || m.playStarted.IsZero() || !shouldScrobble(m.listened, m.total) {
+		return m, nil
+	}
+	m.scrobbled = true
+	return m, scrobble(listenOf(m.currPlaying, m.playStarted))
+}
+
+type listenBrainz struct{ listenBrainzConfig }
+
+func (listenBrainz) name() string   { return "listenbrainz" }
+func (listenBrainz) batchSize() int { return 100 }
+
+func (lb listenBrainz) nowPlaying(l listen) error {
+	return lb.post("playing_now", []listen{l})
+}
+
+func (lb listenBrainz) submit(listens []listen) error {
+	listenType := "import"
+	if len(listens) == 1 {
+		listenType = "single"
+	}
+	return lb.post(listenType, listens)
+}
+
+func (lb listenBrainz) post(listenType string, listens []listen) error {
+	type trackMetadata struct {
+		ArtistName     string         `json:"artist_name"`
+		TrackName      string         `json:"track_name"`
+		ReleaseName    string         `json:"release_name,omitempty"`
+		AdditionalInfo map[string]any `json:"additional_info"`
+	}
+	type payload struct {
+		ListenedAt    int64         `json:"listened_at,omitempty"`
+		TrackMetadata trackMetadata `json:"track_metadata"`
+	}
+
+	body := struct {
+		ListenType string    `json:"listen_type"`
+		Payload    []payload `json:"payload"`
+	}{ListenType: listenType}
+	for _, l := range listens {
+		p := payload{TrackMetadata: trackMetadata{
+			ArtistName:     l.Artist,
+			TrackName:      l.Title,
+			ReleaseName:    l.Album,
+			AdditionalInfo: map[string]any{"media_player": "podden", "submission_client": "podden"},
+		}}
+		if l.Duration > 0 {
+			p.TrackMetadata.AdditionalInfo["duration_ms"] = int(l.Duration * 1000)
+		}
+		if listenType != "playing_now" {
+			p.ListenedAt = l.Started.Unix()
+		}
+		body.Payload = append(body.Payload, p)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	base := cmp.Or(lb.URL, listenBrainzURL)
+	req, err := http.NewRequest("POST", strings.TrimSuffix(base, "/")+"/1/submit-listens", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+lb.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := scrobbleClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		err := fmt.Errorf("%s %s", resp.Status, e.Error)
+		if listenBrainzRejected(resp.StatusCode) {
+			return fmt.Errorf("%w: %w", errRejected, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// client errors other than a bad token or too many requests are about the
+// listens themselves
+func listenBrainzRejected(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
+
+type lastFM struct{ lastFMConfig }
+
+func (lastFM) name() string   { return "lastfm" }
+func (lastFM) batchSize() int { return 50 }
+
+func (fm lastFM) nowPlaying(l listen) error {
+	params := url.Values{
+		"method": {"track.updateNowPlaying"},
+		"artist": {l.Artist},
+		"track":  {l.Title},
+	}
+	if l.Album != "" {
+		params.Set("album", l.Album)
+	}
+	if l.Duration > 0 {
+		params.Set("duration", strconv.Itoa(int(l.Duration)))
+	}
+	return fm.call(params)
+}
+
+func (fm lastFM) submit(listens []listen) error {
+	params := url.Values{"method": {"track.scrobble"}}
+	for i, l := range listens {
+		n := "[" + strconv.Itoa(i) + "]"
+		params.Set("artist"+n, l.Artist)
+		params.Set("track"+n, l.Title)
+		params.Set("timestamp"+n, strconv.FormatInt(l.Started.Unix(), 10))
+		if l.Album != "" {
+			params.Set("album"+n, l.Album)
+		}
+		if l.Duration > 0 {
+			params.Set("duration"+n, strconv.Itoa(int(l.Duration)))
+		}
+	}
+	return fm.call(params)
+}
+
+// Last.fm errors worth trying again: the service being down or busy, and
+// credentials or signatures that a config change can fix. any other error is
+// about the call itself, see https://www.last.fm/api/errorcodes
+var lastFMRetried = map[int]bool{
+	4:  true, // authentication failed
+	8:  true, // operation failed
+	9:  true, // invalid session key
+	10: true, // invalid api key
+	11: true, // service offline
+	13: true, // invalid method signature
+	16: true, // temporarily unavailable
+	26: true, // suspended api key
+	29: true, // rate limit exceeded
+}
+
+// sign and post an api call, see https://www.last.fm/api/authspec
+func (fm lastFM) call(params url.Values) error {
+	params.Set("api_key", fm.APIKey)
+	params.Set("sk", fm.SessionKey)
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sig strings.Builder
+	for _, name := range names {
+		sig.WriteString(name + params.Get(name))
+	}
+	sig.WriteString(fm.Secret)
+	sum := md5.Sum([]byte(sig.String()))
+	params.Set("api_sig", hex.EncodeToString(sum[:]))
+	params.Set("format", "json")
+
+	resp, err := scrobbleClient.PostForm(cmp.Or(fm.URL, lastFMURL), params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var e struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(data, &e)
+	if e.Error != 0 {
+		err := fmt.Errorf("error %d: %s", e.Error, e.Message)
+		if !lastFMRetried[e.Error] {
+			return fmt.Errorf("%w: %w", errRejected, err)
+		}
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
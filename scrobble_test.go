@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a fake ListenBrainz that refuses listens of a track called "bad", or
+// everything while down
+type fakeListenBrainz struct {
+	mu      sync.Mutex
+	down    bool
+	batches []int    // size of every accepted submission
+	tracks  []string // accepted tracks, in order
+	types   []string // listen_type of every request
+}
+
+func (f *fakeListenBrainz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var body struct {
+		ListenType string `json:"listen_type"`
+		Payload    []struct {
+			TrackMetadata struct {
+				TrackName string `json:"track_name"`
+			} `json:"track_metadata"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.types = append(f.types, body.ListenType)
+	for _, p := range body.Payload {
+		if p.TrackMetadata.TrackName == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad listen"})
+			return
+		}
+	}
+	for _, p := range body.Payload {
+		f.tracks = append(f.tracks, p.TrackMetadata.TrackName)
+	}
+	f.batches = append(f.batches, len(body.Payload))
+}
+
+func (f *fakeListenBrainz) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+// point the config and the queue at a fake server and a temporary directory
+func setupScrobbling(t *testing.T) *fakeListenBrainz {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir, err := configDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeListenBrainz{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	old := cfg()
+	t.Cleanup(func() { currentConfig.Store(old) })
+	c := &config{}
+	c.Scrobble.ListenBrainz = listenBrainzConfig{Token: "secret", URL: server.URL}
+	currentConfig.Store(c)
+	return fake
+}
+
+func queueListens(t *testing.T, titles ...string) {
+	t.Helper()
+	var queued []queuedListen
+	for i, title := range titles {
+		l := listen{Artist: "artist", Title: title, Started: time.Unix(int64(i), 0)}
+		queued = append(queued, queuedListen{"listenbrainz", l})
+	}
+	if err := appendScrobbles(queued); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queuedTitles(t *testing.T, path func() (string, error)) []string {
+	t.Helper()
+	p, err := path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := readScrobbles(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, q := range queued {
+		titles = append(titles, q.Title)
+	}
+	return titles
+}
+
+func TestFlushScrobblesBatches(t *testing.T) {
+	fake := setupScrobbling(t)
+	var titles []string
+	for i := range 150 {
+		titles = append(titles, fmt.Sprint(i))
+	}
+	queueListens(t, titles...)
+
+	msg := flushScrobbles()
+	if msg.err != nil || msg.pending != 0 {
+		t.Fatalf("flush = %d pending, %v, want everything sent", msg.pending, msg.err)
+	}
+	if fmt.Sprint(fake.batches) != "[100 50]" {
+		t.Errorf("batches = %v, want [100 50]", fake.batches)
+	}
+	if fmt.Sprint(fake.types) != "[import import]" {
+		t.Errorf("listen types = %v, want imports", fake.types)
+	}
+	if fmt.Sprint(fake.tracks) != fmt.Sprint(titles) {
+		t.Errorf("tracks were sent out of order: %v", fake.tracks)
+	}
+	path, _ := scrobbleQueuePath()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("queue file still there after sending everything: %v", err)
+	}
+}
+
+func TestFlushScrobblesSetsRejectedAside(t *testing.T) {
+	fake := setupScrobbling(t)
+	queueListens(t, "a", "bad", "b")
+
+	msg := flushScrobbles()
+	if msg.err == nil {
+		t.Error("flush didn't report the rejected listen")
+	}
+	if msg.pending != 0 {
+		t.Errorf("%d listens still queued, want the rejected one moved out", msg.pending)
+	}
+	if fmt.Sprint(fake.tracks) != "[a b]" {
+		t.Errorf("sent %v, want [a b]", fake.tracks)
+	}
+	if got := queuedTitles(t, rejectedScrobblesPath); fmt.Sprint(got) != "[bad]" {
+		t.Errorf("rejected file has %v, want [bad]", got)
+	}
+}
+
+func TestFlushScrobblesKeepsQueueWhileDown(t *testing.T) {
+	fake := setupScrobbling(t)
+	fake.setDown(true)
+	queueListens(t, "a", "b")
+
+	msg := flushScrobbles()
+	if msg.err == nil || msg.pending != 2 {
+		t.Fatalf("flush = %d pending, %v, want both kept with an error", msg.pending, msg.err)
+	}
+	if got := queuedTitles(t, scrobbleQueuePath); fmt.Sprint(got) != "[a b]" {
+		t.Errorf("queue has %v, want [a b]", got)
+	}
+
+	// the next flush once the service is back sends them
+	fake.setDown(false)
+	queueListens(t, "c")
+	if msg := flushScrobbles(); msg.err != nil || msg.pending != 0 {
+		t.Fatalf("flush = %d pending, %v, want everything sent", msg.pending, msg.err)
+	}
+	if fmt.Sprint(fake.tracks) != "[a b c]" {
+		t.Errorf("sent %v, want [a b c]", fake.tracks)
+	}
+	if got := queuedTitles(t, rejectedScrobblesPath); len(got) != 0 {
+		t.Errorf("listens set aside while the service was down: %v", got)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sahilm/fuzzy"
+)
+
+// how many results of each kind the search page shows
+const searchLimit = 50
+
+func newSearchInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = "search: "
+	input.Placeholder = `anything, or artist:"name" year:1990..1999 -title:remix`
+	input.Cursor.SetMode(cursor.CursorStatic)
+	return input
+}
+
+// open the search page, or go back to typing if it's already open
+func (m model) startSearch() (model, tea.Cmd) {
+	if m.page.kind != searchPage {
+		m = m.pushHistory()
+		m.search.SetValue("")
+		m = m.setPage(page{kind: searchPage}, nil)
+	}
+	m.playing = false
+	m.searching = true
+	cmd := m.search.Focus()
+	m.list.Title = m.search.View()
+	return m.showList().resize(), cmd
+}
+
+// keys typed into the search prompt, results update as you type
+func (m model) updateSearch(msg tea.KeyMsg) (model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.searching = false
+		m.search.Blur()
+
+	case tea.KeyEsc:
+		m.searching = false
+		m.search.Blur()
+		if m.search.Value() == "" {
+			return m.back()
+		}
+
+	// arrows move through the results without leaving the prompt
+	case tea.KeyUp, tea.KeyDown:
+		m.list, cmd = m.list.Update(msg)
+
+	default:
+		m.search, cmd = m.search.Update(msg)
+		if m.search.Value() != m.page.key {
+			m.page.key = m.search.Value()
+			m.list.SetItems(m.page.items())
+			// skip the first heading
+			m.list.Select(min(1, max(len(m.list.Items())-1, 0)))
+		}
+	}
+
+	m.list.Title = m.search.View()
+	return m, cmd
+}
+
+// songs, albums and artists fuzzy matching query, best matches first, or
+// the songs matching it when it's written in the query language
+func searchItems(query string) []list.Item {
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+	if isQuery(query) {
+		return queryItems(query)
+	}
+	musics := lib.all()
+
+	var items []list.Item
+	items = appendResults(items, "Songs", fuzzyFind(query, musics, func(m music) []string {
+		return []string{m.title, m.artist, m.album, m.genre}
+	}))
+	items = appendResults(items, "Albums", fuzzyFind(query, groupAlbums(musics), func(a album) []string {
+		return []string{a.title, a.artist}
+	}))
+	items = appendResults(items, "Artists", fuzzyFind(query, groupArtists(musics), func(a artist) []string {
+		return []string{a.name}
+	}))
+	return items
+}
+
+// a heading followed by the results, nothing if there are none
+func appendResults[T list.Item](items []list.Item, title string, results []T) []list.Item {
+	if len(results) == 0 {
+		return items
+	}
+	items = append(items, heading{fmt.Sprintf("%s (%d)", title, len(results))})
+	for _, r := range results {
+		items = append(items, r)
+	}
+	return items
+}
+
+// the values with a field that fuzzy matches query, best first. each field
+// is matched on its own and the best one counts, so a query can't match by
+// picking letters out of title, artist and album together
+func fuzzyFind[T any](query string, values []T, fields func(T) []string) []T {
+	var texts [][]string // the values' texts, one slice per field
+	for i, v := range values {
+		for f, text := range fields(v) {
+			if f == len(texts) {
+				texts = append(texts, make([]string, len(values)))
+			}
+			texts[f][i] = text
+		}
+	}
+
+	best := make(map[int]int) // best score by index
+	for _, field := range texts {
+		for _, match := range fuzzy.Find(query, field) {
+			if score, ok := best[match.Index]; !ok || match.Score > score {
+				best[match.Index] = match.Score
+			}
+		}
+	}
+
+	indexes := slices.Collect(maps.Keys(best))
+	slices.SortFunc(indexes, func(a, b int) int {
+		return cmp.Or(cmp.Compare(best[b], best[a]), cmp.Compare(a, b))
+	})
+	results := make([]T, 0, min(len(indexes), searchLimit))
+	for _, i := range indexes[:min(len(indexes), searchLimit)] {
+		results = append(results, values[i])
+	}
+	return results
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+type sortMode int
+
+const (
+	sortNatural  sortMode = iota // the page's own order, by path for songs
+	sortTitle                    // or name
+	sortArtist                   // then album
+	sortAlbum                    // then disc and track
+	sortYear                     // oldest first
+	sortAdded                    // newest first
+	sortPlays                    // most played first
+	sortDuration                 // shortest first
+)
+
+func (s sortMode) String() string {
+	switch s {
+	case sortTitle:
+		return "title"
+	case sortArtist:
+		return "artist"
+	case sortAlbum:
+		return "album"
+	case sortYear:
+		return "year"
+	case sortAdded:
+		return "date added"
+	case sortPlays:
+		return "play count"
+	case sortDuration:
+		return "duration"
+	}
+	return ""
+}
+
+// the orders a page can be cycled through, pages with headings or a fixed
+// order like album tracks have none
+func sortModes(kind pageKind) []sortMode {
+	switch kind {
+	case songsPage, artistTracksPage, genreTracksPage, decadeTracksPage, smartPlaylistPage, autoPlaylistPage:
+		return []sortMode{sortNatural, sortTitle, sortArtist, sortAlbum, sortYear, sortAdded, sortPlays, sortDuration}
+	case albumsPage:
+		return []sortMode{sortNatural, sortTitle, sortArtist, sortYear, sortAdded, sortPlays, sortDuration}
+	case artistsPage:
+		return []sortMode{sortNatural, sortTitle, sortAdded, sortPlays}
+	case genresPage:
+		// already sorted by name
+		return []sortMode{sortNatural, sortAdded, sortPlays}
+	}
+	return nil
+}
+
+// switch the current page to its next sort order, keeping the cursor on the
+// same item. new pages of the same kind open in that order too. the stats
+// page switches between periods instead
+func (m model) cycleSort() (model, tea.Cmd) {
+	if m.page.kind == statsPage {
+		return m.cycleStatsPeriod()
+	}
+	modes := sortModes(m.page.kind)
+	if len(modes) < 2 {
+		return m, nil
+	}
+	i := slices.Index(modes, m.page.sort)
+	m.page.sort = modes[(i+1)%len(modes)]
+
+	if m.sorts == nil {
+		m.sorts = make(map[pageKind]sortMode)
+	}
+	m.sorts[m.page.kind] = m.page.sort
+
+	m.list.Title = m.page.listTitle()
+	return m.refreshList()
+}
+
+// sort songs, albums, artists and genres in place
+func sortItems(items []list.Item, mode sortMode) {
+	if mode == sortNatural {
+		return
+	}
+
+	// work the sort keys out once rather than on every comparison
+	c := newCollator()
+	infos := make([]sortInfo, len(items))
+	for i, item := range items {
+		infos[i] = sortInfoOf(item)
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int {
+		ia, ib := infos[a], infos[b]
+		var result int
+		switch mode {
+		case sortArtist:
+			result = compareNames(c, ia.artist, ib.artist)
+			if result == 0 {
+				result = compareNames(c, ia.album, ib.album)
+			}
+		case sortAlbum:
+			result = compareNames(c, ia.album, ib.album)
+			if result == 0 {
+				result = cmp.Compare(ia.disc*1000+ia.track, ib.disc*1000+ib.track)
+			}
+		case sortYear:
+			result = cmp.Compare(yearKey(ia.year), yearKey(ib.year))
+		case sortAdded:
+			result = ib.added.Compare(ia.added)
+		case sortPlays:
+			result = cmp.Compare(ib.plays, ia.plays)
+		case sortDuration:
+			result = cmp.Compare(ia.duration, ib.duration)
+		}
+		if result == 0 {
+			result = compareNames(c, ia.title, ib.title)
+		}
+		return result
+	})
+
+	sorted := make([]list.Item, len(items))
+	for i, j := range order {
+		sorted[i] = items[j]
+	}
+	copy(items, sorted)
+}
+
+// what items are sorted by, summed up over the tracks of albums and artists
+type sortInfo struct {
+	title, artist string
+	album         string
+	disc, track   int
+	year          int
+	added         time.Time
+	plays         int
+	duration      time.Duration
+}
+
+func sortInfoOf(item list.Item) sortInfo {
+	var info sortInfo
+	if item, ok := item.(list.DefaultItem); ok {
+		info.title = item.Title()
+	}
+	switch item := item.(type) {
+	case music:
+		info.artist, info.album = item.artist, item.album
+		info.disc, info.track = discOf(item), item.track
+	case album:
+		info.artist, info.album = item.artist, item.title
+	}
+
+	for i, m := range itemTracks(item) {
+		if i == 0 || (m.year != 0 && m.year < info.year) {
+			info.year = m.year
+		}
+		if m.added.After(info.added) {
+			info.added = m.added
+		}
+		info.plays += lib.playCount(m.path)
+		info.duration += m.duration
+	}
+	return info
+}
+
+// unknown years go last
+func yearKey(year int) int {
+	if year == 0 {
+		return math.MaxInt
+	}
+	return year
+}
+
+// a collator for the user's language that ignores case and orders numbers
+// by value. collators aren't safe to share between goroutines
+func newCollator() *collate.Collator {
+	return collate.New(userLanguage(), collate.IgnoreCase, collate.Numeric)
+}
+
+// the language from LANG, like en_US.UTF-8
+func userLanguage() language.Tag {
+	for _, env := range []string{"LC_ALL", "LC_COLLATE", "LANG"} {
+		if value := os.Getenv(env); value != "" && value != "C" && value != "POSIX" {
+			value, _, _ = strings.Cut(value, ".")
+			if tag, err := language.Parse(strings.ReplaceAll(value, "_", "-")); err == nil {
+				return tag
+			}
+		}
+	}
+	return language.English
+}
+
+// compare names ignoring a leading "The", so The Beatles sort under B
+func compareNames(c *collate.Collator, a, b string) int {
+	return c.CompareString(stripArticle(a), stripArticle(b))
+}
+
+func stripArticle(s string) string {
+	if len(s) > 4 && strings.EqualFold(s[:4], "the ") {
+		return s[4:]
+	}
+	return s
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// how many top tracks, artists and albums the stats page lists
+const statsTop = 10
+
+// the periods the stats page cycles through, keys of the page
+var statsPeriods = []string{"week", "month", "all"}
+
+// a line of the stats page
+type statItem struct {
+	title string
+	desc  string
+}
+
+func (s statItem) Title() string       { return s.title }
+func (s statItem) Description() string { return s.desc }
+func (s statItem) FilterValue() string { return s.title }
+
+func periodName(period string) string {
+	switch period {
+	case "week":
+		return "last 7 days"
+	case "month":
+		return "last 30 days"
+	}
+	return "all time"
+}
+
+// when a period starts, zero for all time
+func periodStart(period string, now time.Time) time.Time {
+	switch period {
+	case "week":
+		return now.AddDate(0, 0, -7)
+	case "month":
+		return now.AddDate(0, 0, -30)
+	}
+	return time.Time{}
+}
+
+// open the stats page for the last 7 days
+func (m model) showStats() model {
+	p := page{kind: statsPage, key: statsPeriods[0]}
+	p.title = "Stats · " + periodName(p.key)
+	return m.switchPage(statsPage).setPage(p, p.items())
+}
+
+// switch the stats page to the next period
+func (m model) cycleStatsPeriod() (model, tea.Cmd) {
+	i := slices.Index(statsPeriods, m.page.key)
+	m.page.key = statsPeriods[(i+1)%len(statsPeriods)]
+	m.page.title = "Stats · " + periodName(m.page.key)
+	m.list.Title = m.page.listTitle()
+	m, cmd := m.refreshList()
+	m.list.Select(0)
+	return m, cmd
+}
+
+// listening time and the most played tracks, artists and albums of a period
+func statsItems(period string) []list.Item {
+	start := periodStart(period, time.Now())
+	var listened time.Duration
+	tracks := newTally()
+	artists := newTally()
+	albums := newTally()
+
+	for _, p := range history {
+		if p.Started.Before(start) {
+			continue
+		}
+		heard := p.listened()
+		listened += heard
+		if !p.counts() {
+			continue
+		}
+		tracks.add(p.Path, p.Title, p.Artist, heard)
+		artists.add(p.Artist, p.Artist, "", heard)
+		if p.Album != "" {
+			albums.add(p.Artist+" - "+p.Album, p.Album, p.Artist, heard)
+		}
+	}
+
+	items := []list.Item{heading{fmt.Sprintf("%s listened · %s", listeningTime(listened), playCountText(tracks.plays))}}
+	items = append(items, tracks.top("Top tracks")...)
+	items = append(items, artists.top("Top artists")...)
+	items = append(items, albums.top("Top albums")...)
+	return items
+}
+
+// plays counted by key
+type tally struct {
+	entries map[string]*tallyEntry
+	plays   int
+}
+
+type tallyEntry struct {
+	title, subtitle string
+	plays           int
+	listened        time.Duration
+}
+
+func newTally() *tally {
+	return &tally{entries: make(map[string]*tallyEntry)}
+}
+
+func (t *tally) add(key, title, subtitle string, listened time.Duration) {
+	e, ok := t.entries[key]
+	if !ok {
+		e = &tallyEntry{title: title, subtitle: subtitle}
+		t.entries[key] = e
+	}
+	e.plays++
+	e.listened += listened
+	t.plays++
+}
+
+// a heading and the most played entries, nothing when there are none
+func (t *tally) top(title string) []list.Item {
+	if len(t.entries) == 0 {
+		return nil
+	}
+	entries := make([]*tallyEntry, 0, len(t.entries))
+	for _, e := range t.entries {
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *tallyEntry) int {
+		if c := cmp.Compare(b.plays, a.plays); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.listened, a.listened); c != 0 {
+			return c
+		}
+		return naturalCompare(a.title, b.title)
+	})
+
+	items := []list.Item{heading{title}}
+	for i, e := range entries[:min(len(entries), statsTop)] {
+		items = append(items, statItem{
+			title: fmt.Sprintf("%d. %s", i+1, e.title),
+			desc:  joinInfo(e.subtitle, playCountText(e.plays), listeningTime(e.listened)),
+		})
+	}
+	return items
+}
+
+func playCountText(n int) string {
+	if n == 1 {
+		return "1 play"
+	}
+	return fmt.Sprintf("%d plays", n)
+}
+
+// 3h 20m, or 45m under an hour
+func listeningTime(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d >= time.Hour {
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%dm", int(d.Minutes()))
+}
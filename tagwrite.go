@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// room left after rewritten tags so later edits don't have to move the audio
+const tagPadding = 1024
+
+// the email POPM frames are written with, the one most players look for
+const popmEmail = "no@email"
+
+// write a 0 to 5 star rating into the file's tags, 0 removes it
+func writeRatingTag(path string, stars int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		out, err = setPOPM(data, stars)
+	case ".flac":
+		out, err = setFMPSRating(data, stars)
+	default:
+		err = errors.New("writing ratings is only supported for mp3 and flac")
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, out)
+}
+
+// write data to a temporary file next to path and move it over path, so a
+// crash never leaves a half written song. the modification time is kept so
+// the song doesn't jump to the top of Recently Added
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".podden-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), info.Mode()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(f.Name(), time.Time{}, info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// stars to the POPM rating byte, as Windows Media Player writes it
+var popmRatings = []byte{0, 1, 64, 128, 196, 255}
+
+// the star rating of a POPM frame body
+func popmStars(body []byte) int {
+	i := bytes.IndexByte(body, 0)
+	if i < 0 || i+1 >= len(body) {
+		return 0
+	}
+	switch r := body[i+1]; {
+	case r == 0:
+		return 0
+	case r < 32:
+		return 1
+	case r < 96:
+		return 2
+	case r < 160:
+		return 3
+	case r < 224:
+		return 4
+	}
+	return 5
+}
+
+// the star rating of an FMPS_RATING value between 0 and 1
+func fmpsStars(value string) int {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f <= 0 {
+		return 0
+	}
+	return min(int(f*5+0.5), 5)
+}
+
+// replace the POPM frames of an id3v2.3 or 2.4 tag, adding a tag if the file
+// has none
+func setPOPM(data []byte, stars int) ([]byte, error) {
+	var frames []id3Frame
+	if stars > 0 {
+		frames = append(frames, id3Frame{"POPM", append([]byte(popmEmail+"\x00"), popmRatings[stars])})
+	}
+	return rewriteID3(data, func(id string, _ []byte) bool { return id == "POPM" }, frames)
+}
+
+type id3Frame struct {
+	id   string
+	body []byte
+}
+
+// a latin-1 TXXX frame, user defined text
+func txxxFrame(name, value string) id3Frame {
+	return id3Frame{"TXXX", []byte("\x00" + name + "\x00" + value)}
+}
+
+// the name of a TXXX frame in any of the id3 text encodings
+func txxxName(body []byte) string {
+	if len(body) < 1 {
+		return ""
+	}
+	text := body[1:]
+	if body[0] == 1 || body[0] == 2 {
+		// utf-16, with or without a byte order mark
+		bigEndian := body[0] == 2
+		if len(text) >= 2 && (text[0] == 0xfe && text[1] == 0xff || text[0] == 0xff && text[1] == 0xfe) {
+			bigEndian = text[0] == 0xfe
+			text = text[2:]
+		}
+		var name []rune
+		for i := 0; i+1 < len(text); i += 2 {
+			c := rune(text[i]) | rune(text[i+1])<<8
+			if bigEndian {
+				c = rune(text[i])<<8 | rune(text[i+1])
+			}
+			if c == 0 {
+				break
+			}
+			name = append(name, c)
+		}
+		return string(name)
+	}
+	name, _, _ := bytes.Cut(text, []byte{0})
+	return string(name)
+}
+
+// rewrite the id3v2.3 or 2.4 tag of an mp3, leaving out the frames drop
+// matches and adding frames. a file without a tag gets a v2.3 one
+func rewriteID3(data []byte, drop func(id string, body []byte) bool, add []id3Frame) ([]byte, error) {
+	version := byte(3)
+	var frames [][]byte
+	audio := data
+
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		version = data[3]
+		flags := data[5]
+		if version != 3 && version != 4 {
+			return nil, fmt.Errorf("id3v2.%d tags can't be written", version)
+		}
+		// unsynchronised tags, extended headers and footers are rare enough
+		// to leave alone
+		if flags&0xd0 != 0 {
+			return nil, errors.New("id3 tag uses features podden can't write")
+		}
+		size := syncsafe(data[6:10])
+		if 10+size > len(data) {
+			return nil, errors.New("id3 tag is cut off")
+		}
+		tag := data[10 : 10+size]
+		audio = data[10+size:]
+
+		for pos := 0; pos+10 <= len(tag) && tag[pos] != 0; {
+			var frameSize int
+			if version == 4 {
+				frameSize = syncsafe(tag[pos+4 : pos+8])
+			} else {
+				frameSize = int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+			}
+			end := pos + 10 + frameSize
+			if end > len(tag) {
+				return nil, errors.New("id3 frame is cut off")
+			}
+			if !drop(string(tag[pos:pos+4]), tag[pos+10:end]) {
+				frames = append(frames, tag[pos:end])
+			}
+			pos = end
+		}
+	}
+
+	for _, f := range add {
+		frame := []byte(f.id)
+		if version == 4 {
+			frame = append(frame, putSyncsafe(len(f.body))...)
+		} else {
+			frame = binary.BigEndian.AppendUint32(frame, uint32(len(f.body)))
+		}
+		frame = append(frame, 0, 0)
+		frames = append(frames, append(frame, f.body...))
+	}
+
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, tagPadding)...)
+
+	var out bytes.Buffer
+	out.WriteString("ID3")
+	out.Write([]byte{version, 0, 0})
+	out.Write(putSyncsafe(len(body)))
+	out.Write(body)
+	out.Write(audio)
+	return out.Bytes(), nil
+}
+
+// 28 bit integers stored in the low 7 bits of 4 bytes
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func putSyncsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+}
+
+type flacBlock struct {
+	kind byte
+	body []byte
+}
+
+const (
+	flacPadding       = 1
+	flacVorbisComment = 4
+)
+
+// replace FMPS_RATING in the vorbis comments of a flac file
+func setFMPSRating(data []byte, stars int) ([]byte, error) {
+	return setFLACComments(data, map[string]string{"FMPS_RATING": fmpsValue(stars)})
+}
+
+// set vorbis comments of a flac file, empty values remove them
+func setFLACComments(data []byte, comments map[string]string) ([]byte, error) {
+	if len(data) < 4 || string(data[:4]) != "fLaC" {
+		return nil, errors.New("not a flac file")
+	}
+
+	var blocks []flacBlock
+	pos := 4
+	for last := false; !last; {
+		if pos+4 > len(data) {
+			return nil, errors.New("flac metadata is cut off")
+		}
+		last = data[pos]&0x80 != 0
+		kind := data[pos] & 0x7f
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		if pos+4+size > len(data) {
+			return nil, errors.New("flac metadata is cut off")
+		}
+		if kind != flacPadding {
+			blocks = append(blocks, flacBlock{kind, data[pos+4 : pos+4+size]})
+		}
+		pos += 4 + size
+	}
+	audio := data[pos:]
+
+	found := false
+	for i, b := range blocks {
+		if b.kind == flacVorbisComment {
+			body, err := setVorbisComments(b.body, comments)
+			if err != nil {
+				return nil, err
+			}
+			blocks[i].body = body
+			found = true
+		}
+	}
+	if !found {
+		body, err := setVorbisComments(nil, comments)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, flacBlock{flacVorbisComment, body})
+	}
+	blocks = append(blocks, flacBlock{flacPadding, make([]byte, tagPadding)})
+
+	var out bytes.Buffer
+	out.WriteString("fLaC")
+	for i, b := range blocks {
+		if len(b.body) >= 1<<24 {
+			return nil, errors.New("flac metadata block too big")
+		}
+		header := b.kind
+		if i == len(blocks)-1 {
+			header |= 0x80
+		}
+		out.Write([]byte{header, byte(len(b.body) >> 16), byte(len(b.body) >> 8), byte(len(b.body))})
+		out.Write(b.body)
+	}
+	out.Write(audio)
+	return out.Bytes(), nil
+}
+
+// FMPS ratings go from 0 to 1, empty removes the comment
+func fmpsValue(stars int) string {
+	if stars == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(stars)/5, 'f', 1, 64)
+}
+
+// set or with an empty value remove comments in a vorbis comment block. a
+// nil block starts a new one
+func setVorbisComments(block []byte, values map[string]string) ([]byte, error) {
+	vendor := []byte("podden")
+	var comments [][]byte
+
+	if block != nil {
+		r := bytes.NewReader(block)
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil || int(n) > r.Len() {
+			return nil, errors.New("broken vorbis comments")
+		}
+		vendor = make([]byte, n)
+		r.Read(vendor)
+
+		var count uint32
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return nil, errors.New("broken vorbis comments")
+		}
+		for range count {
+			if err := binary.Read(r, binary.LittleEndian, &n); err != nil || int(n) > r.Len() {
+				return nil, errors.New("broken vorbis comments")
+			}
+			comment := make([]byte, n)
+			r.Read(comment)
+
+			key, _, _ := bytes.Cut(comment, []byte("="))
+			replaced := false
+			for name := range values {
+				replaced = replaced || strings.EqualFold(string(key), name)
+			}
+			if !replaced {
+				comments = append(comments, comment)
+			}
+		}
+	}
+	names := slices.Sorted(maps.Keys(values))
+	for _, name := range names {
+		if values[name] != "" {
+			comments = append(comments, []byte(name+"="+values[name]))
+		}
+	}
+
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	out = append(out, vendor...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+	for _, c := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c)))
+		out = append(out, c...)
+	}
+	return out, nil
+}
+
+// the ReplayGain tags as foobar2000 and loudgain write them, leaving out the
+// track ones unless track is set
+func replayGainTags(rg replayGain, track bool) map[string]string {
+	tags := map[string]string{
+		"REPLAYGAIN_ALBUM_GAIN": "",
+		"REPLAYGAIN_ALBUM_PEAK": "",
+	}
+	if track {
+		tags["REPLAYGAIN_TRACK_GAIN"] = fmt.Sprintf("%.2f dB", rg.trackGain)
+		tags["REPLAYGAIN_TRACK_PEAK"] = fmt.Sprintf("%.6f", rg.trackPeak)
+	}
+	if rg.hasAlbum {
+		tags["REPLAYGAIN_ALBUM_GAIN"] = fmt.Sprintf("%.2f dB", rg.albumGain)
+		tags["REPLAYGAIN_ALBUM_PEAK"] = fmt.Sprintf("%.6f", rg.albumPeak)
+	}
+	return tags
+}
+
+// write measured gains as TXXX frames in mp3s and vorbis comments in flacs,
+// replacing older ones. the track tags are left alone unless track is set
+func writeReplayGainTags(path string, rg replayGain, track bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tags := replayGainTags(rg, track)
+	var out []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		var frames []id3Frame
+		for _, name := range slices.Sorted(maps.Keys(tags)) {
+			if tags[name] != "" {
+				frames = append(frames, txxxFrame(name, tags[name]))
+			}
+		}
+		out, err = rewriteID3(data, func(id string, body []byte) bool {
+			if id != "TXXX" {
+				return false
+			}
+			_, ok := tags[strings.ToUpper(txxxName(body))]
+			return ok
+		}, frames)
+	case ".flac":
+		out, err = setFLACComments(data, tags)
+	default:
+		err = errors.New("writing ReplayGain tags is only supported for mp3 and flac")
+	}
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, out)
+}
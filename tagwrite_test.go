@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dhowden/tag"
+)
+
+// stands in for the audio after the tags, it must come through untouched
+var fakeAudio = bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x64}, 64)
+
+// an mp3 with an id3v2 tag of the given version holding frames
+func id3File(version byte, frames ...id3Frame) []byte {
+	var body []byte
+	for _, f := range frames {
+		body = append(body, f.id...)
+		if version == 4 {
+			body = append(body, putSyncsafe(len(f.body))...)
+		} else {
+			body = binary.BigEndian.AppendUint32(body, uint32(len(f.body)))
+		}
+		body = append(body, 0, 0)
+		body = append(body, f.body...)
+	}
+	body = append(body, make([]byte, 16)...) // padding
+
+	data := []byte{'I', 'D', '3', version, 0, 0}
+	data = append(data, putSyncsafe(len(body))...)
+	data = append(data, body...)
+	return append(data, fakeAudio...)
+}
+
+func textFrame(id, text string) id3Frame {
+	return id3Frame{id, []byte("\x00" + text)}
+}
+
+// a TXXX frame in utf-16 with a byte order mark, as some taggers write them
+func utf16TXXX(name, value string) id3Frame {
+	body := []byte{1}
+	for _, s := range []string{name, value} {
+		body = append(body, 0xff, 0xfe)
+		for _, c := range utf16.Encode([]rune(s)) {
+			body = binary.LittleEndian.AppendUint16(body, c)
+		}
+		body = append(body, 0, 0)
+	}
+	return id3Frame{"TXXX", body[:len(body)-2]}
+}
+
+func readTags(t *testing.T, data []byte) tag.Metadata {
+	t.Helper()
+	metadata, err := tag.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("can't read the rewritten tags: %v", err)
+	}
+	return metadata
+}
+
+func writeTemp(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSetPOPM(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		old := id3Frame{"POPM", []byte(popmEmail + "\x00\x01")}
+		data := id3File(version, textFrame("TIT2", "Song"), old)
+
+		for stars := range maxStars + 1 {
+			out, err := setPOPM(data, stars)
+			if err != nil {
+				t.Fatalf("v2.%d, %d stars: %v", version, stars, err)
+			}
+			metadata := readTags(t, out)
+			if got := tagRating(metadata.Raw()); got != stars {
+				t.Errorf("v2.%d: wrote %d stars, read back %d", version, stars, got)
+			}
+			if metadata.Title() != "Song" {
+				t.Errorf("v2.%d: title became %q", version, metadata.Title())
+			}
+			if out[3] != version {
+				t.Errorf("v2.%d: tag written as v2.%d", version, out[3])
+			}
+			if !bytes.HasSuffix(out, fakeAudio) {
+				t.Errorf("v2.%d: audio changed", version)
+			}
+		}
+	}
+}
+
+func TestSetPOPMAddsTag(t *testing.T) {
+	out, err := setPOPM(fakeAudio, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tagRating(readTags(t, out).Raw()); got != 4 {
+		t.Errorf("read back %d stars, want 4", got)
+	}
+	if !bytes.HasSuffix(out, fakeAudio) {
+		t.Error("audio changed")
+	}
+}
+
+func TestTXXXName(t *testing.T) {
+	tests := []struct {
+		frame id3Frame
+		want  string
+	}{
+		{txxxFrame("replaygain_track_gain", "-6.00 dB"), "replaygain_track_gain"},
+		{utf16TXXX("REPLAYGAIN_TRACK_GAIN", "-6.00 dB"), "REPLAYGAIN_TRACK_GAIN"},
+		{id3Frame{"TXXX", []byte("\x02\x00R\x00G\x00\x00x")}, "RG"}, // utf-16 big endian
+	}
+	for _, tt := range tests {
+		if got := txxxName(tt.frame.body); got != tt.want {
+			t.Errorf("txxxName(% x) = %q, want %q", tt.frame.body, got, tt.want)
+		}
+	}
+}
+
+func TestWriteReplayGainTagsMP3(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		path := writeTemp(t, "song.mp3", id3File(version,
+			textFrame("TIT2", "Song"),
+			utf16TXXX("REPLAYGAIN_TRACK_GAIN", "+3.00 dB"),
+			txxxFrame("replaygain_album_gain", "+2.00 dB"),
+			txxxFrame("MusicBrainz Album Id", "abc"),
+		))
+
+		rg := replayGain{trackGain: -6.5, trackPeak: 0.9, albumGain: -5.25, albumPeak: 0.95, hasTrack: true, hasAlbum: true}
+		if err := writeReplayGainTags(path, rg, true); err != nil {
+			t.Fatalf("v2.%d: %v", version, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := readReplayGain(readTags(t, data).Raw())
+		if got.trackGain != -6.5 || got.trackPeak != 0.9 || got.albumGain != -5.25 || got.albumPeak != 0.95 {
+			t.Errorf("v2.%d: read back %+v", version, got)
+		}
+		// the old gains are replaced whatever their encoding or case, other
+		// TXXX frames stay
+		if n := bytes.Count(data, []byte("TXXX")); n != 5 {
+			t.Errorf("v2.%d: %d TXXX frames, want 4 gains and the album id", version, n)
+		}
+		if !bytes.Contains(data, []byte("MusicBrainz Album Id")) {
+			t.Errorf("v2.%d: other TXXX frame dropped", version)
+		}
+		if !bytes.HasSuffix(data, fakeAudio) {
+			t.Errorf("v2.%d: audio changed", version)
+		}
+	}
+}
+
+func TestWriteReplayGainTagsKeepsTrackGain(t *testing.T) {
+	path := writeTemp(t, "song.mp3", id3File(3, txxxFrame("REPLAYGAIN_TRACK_GAIN", "+3.00 dB")))
+	rg := replayGain{trackGain: -6, albumGain: -5, hasTrack: true, hasAlbum: true}
+	if err := writeReplayGainTags(path, rg, false); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	got := readReplayGain(readTags(t, data).Raw())
+	if got.trackGain != 3 || got.albumGain != -5 {
+		t.Errorf("read back %+v, want the track gain kept and the album gain added", got)
+	}
+}
+
+// a flac with STREAMINFO, an application block, vorbis comments and padding
+func flacFile(comments ...string) []byte {
+	block := func(kind byte, last bool, body []byte) []byte {
+		if last {
+			kind |= 0x80
+		}
+		return append([]byte{kind, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	}
+
+	streamInfo := make([]byte, 34)
+	copy(streamInfo[10:], []byte{0x0a, 0xc4, 0x42, 0xf0, 0, 0, 0xac, 0x44}) // 44.1 kHz, 44100 samples
+
+	vorbis := binary.LittleEndian.AppendUint32(nil, 6)
+	vorbis = append(vorbis, "tagger"...)
+	vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(comments)))
+	for _, c := range comments {
+		vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(c)))
+		vorbis = append(vorbis, c...)
+	}
+
+	data := []byte("fLaC")
+	data = append(data, block(0, false, streamInfo)...)
+	data = append(data, block(2, false, []byte("appl-data"))...)
+	data = append(data, block(flacVorbisComment, false, vorbis)...)
+	data = append(data, block(flacPadding, true, make([]byte, 100))...)
+	return append(data, fakeAudio...)
+}
+
+// kinds and sizes of the metadata blocks of a flac file
+func flacBlocks(t *testing.T, data []byte) (kinds []byte, sizes []int) {
+	t.Helper()
+	pos := 4
+	for last := false; !last; {
+		last = data[pos]&0x80 != 0
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		kinds = append(kinds, data[pos]&0x7f)
+		sizes = append(sizes, size)
+		pos += 4 + size
+	}
+	if !bytes.Equal(data[pos:], fakeAudio) {
+		t.Error("audio changed")
+	}
+	return kinds, sizes
+}
+
+func TestSetFLACComments(t *testing.T) {
+	data := flacFile("TITLE=Song", "fmps_rating=0.2", "REPLAYGAIN_TRACK_GAIN=+3.00 dB")
+
+	out, err := setFMPSRating(data, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds, sizes := flacBlocks(t, out)
+	if !bytes.Equal(kinds, []byte{0, 2, flacVorbisComment, flacPadding}) {
+		t.Errorf("blocks = %v, want streaminfo, application, comments and padding", kinds)
+	}
+	if sizes[0] != 34 || sizes[1] != len("appl-data") || sizes[3] != tagPadding {
+		t.Errorf("block sizes = %v", sizes)
+	}
+	metadata := readTags(t, out)
+	if got := tagRating(metadata.Raw()); got != 4 {
+		t.Errorf("read back %d stars, want 4", got)
+	}
+	if metadata.Title() != "Song" {
+		t.Errorf("title became %q", metadata.Title())
+	}
+
+	// 0 stars removes the comment
+	out, err = setFMPSRating(out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(bytes.ToUpper(out), []byte("FMPS_RATING")) {
+		t.Error("rating still there after removing it")
+	}
+}
+
+func TestWriteReplayGainTagsFLAC(t *testing.T) {
+	path := writeTemp(t, "song.flac", flacFile("TITLE=Song", "replaygain_track_gain=+3.00 dB"))
+	rg := replayGain{trackGain: -6.5, trackPeak: 0.9, hasTrack: true}
+	if err := writeReplayGainTags(path, rg, true); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	flacBlocks(t, data)
+
+	got := readReplayGain(readTags(t, data).Raw())
+	if got.trackGain != -6.5 || got.trackPeak != 0.9 || got.hasAlbum {
+		t.Errorf("read back %+v", got)
+	}
+	if n := bytes.Count(bytes.ToUpper(data), []byte("REPLAYGAIN_TRACK_GAIN")); n != 1 {
+		t.Errorf("%d track gain comments, want the old one replaced", n)
+	}
+}
+
+func TestReplaceFileKeepsModTime(t *testing.T) {
+	path := writeTemp(t, "song.mp3", []byte("old"))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replaceFile(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), modTime)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("content = %q, want new", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+}
+
+func TestFailedWritesLeaveFilesAlone(t *testing.T) {
+	// a tag that claims to be longer than the file can't be rewritten
+	broken := id3File(3, textFrame("TIT2", "Song"))
+	broken[8] = 0x7f
+	path := writeTemp(t, "broken.mp3", broken)
+	if err := writeRatingTag(path, 3); err == nil {
+		t.Error("rating written into a broken tag")
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, broken) {
+		t.Error("broken file changed")
+	}
+
+	// a move that fails leaves the target and no temporary file behind
+	dir := t.TempDir()
+	target := filepath.Join(dir, "song.mp3")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(target, "keep"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceFile(target, []byte("new")); err == nil {
+		t.Error("replaced a directory")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary file left behind: %v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(target, "keep")); err != nil {
+		t.Errorf("target changed: %v", err)
+	}
+}
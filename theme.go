@@ -0,0 +1,193 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+//go:embed themes/*.yml
+var builtinThemes embed.FS
+
+type theme struct {
+	HeadingForeground             string `yaml:"heading_foreground"`
+	HeadingBackground             string `yaml:"heading_background"`
+	BorderForeground              string `yaml:"border_foreground"`
+	NormalTitleForeground         string `yaml:"normal_title_foreground"`
+	NormalDescForeground          string `yaml:"normal_desc_foreground"`
+	SelectedTitleBorderForeground string `yaml:"selected_title_border_foreground"`
+	SelectedTitleForeground       string `yaml:"selected_title_foreground"`
+	SelectedDescForeground        string `yaml:"selected_desc_foreground"`
+	DimmedTitleForeground         string `yaml:"dimmed_title_foreground"`
+	DimmedDescForeground          string `yaml:"dimmed_desc_foreground"`
+	ArtistForeground              string `yaml:"artist_foreground"`
+	TimeForeground                string `yaml:"time_foreground"`
+	LyricsForeground              string `yaml:"lyrics_foreground"`
+}
+
+// sent when config.yml or the theme file changed on disk
+type (
+	configMsg struct{}
+	themeMsg  struct{}
+)
+
+// the theme the styles are built from
+var activeTheme theme
+
+func themesDir() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "themes"), nil
+}
+
+// name of the theme picked with -t or in the config
+func themeName() string {
+	if *themeFlag != "" {
+		return *themeFlag
+	}
+	if name := cfg().Theme; name != "" {
+		return name
+	}
+	return "default"
+}
+
+// names of the built-in presets
+func builtinThemeNames() []string {
+	entries, _ := builtinThemes.ReadDir("themes")
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yml"))
+	}
+	return names
+}
+
+// find a theme by name, files in the themes directory win over built-in presets
+func readTheme(name string) (theme, []string, error) {
+	var t theme
+
+	data, err := readThemeFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = builtinThemes.ReadFile("themes/" + name + ".yml")
+		if errors.Is(err, fs.ErrNotExist) {
+			return t, nil, fmt.Errorf("theme %q not found, built-in themes are: %s",
+				name, strings.Join(builtinThemeNames(), ", "))
+		}
+	}
+	if err != nil {
+		return t, nil, err
+	}
+
+	warnings, err := decodeYaml(data, "themes/"+name+".yml", &t)
+	return t, warnings, err
+}
+
+func readThemeFile(name string) ([]byte, error) {
+	dir, err := themesDir()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name+".yml"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.ReadFile(filepath.Join(dir, name+".yaml"))
+	}
+	return data, err
+}
+
+// t with every colour set in overrides replaced
+func (t theme) with(overrides theme) theme {
+	tv := reflect.ValueOf(&t).Elem()
+	ov := reflect.ValueOf(overrides)
+	for i := range ov.NumField() {
+		if value := ov.Field(i).String(); value != "" {
+			tv.Field(i).SetString(value)
+		}
+	}
+	return t
+}
+
+// load the selected theme and apply the colours set in the config on top
+func loadTheme() ([]string, error) {
+	t, warnings, err := readTheme(themeName())
+	if err != nil {
+		return warnings, err
+	}
+	activeTheme = t.with(cfg().theme)
+	return warnings, nil
+}
+
+// rebuild styles and bindings after the config or theme changed
+func (m model) restyle() model {
+	initStyles()
+	if m.loaded || m.showAlbums || m.showArtists {
+		m.list.SetDelegate(customDelegate())
+		m.list.Styles = setCustomBubblesStyle()
+		m.list.KeyMap = listKeys()
+	}
+	return m
+}
+
+// tell the program whenever config.yml or the active theme changes on disk
+func watchConfig(p *tea.Program) {
+	dir, err := configDir()
+	if err != nil {
+		return
+	}
+	themes := filepath.Join(dir, "themes")
+	os.MkdirAll(themes, 0755)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	defer watcher.Close()
+
+	// editors often replace files instead of writing them, so watch the
+	// directories rather than the files
+	if err := watcher.Add(dir); err != nil {
+		return
+	}
+	if err := watcher.Add(themes); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			name := strings.TrimSuffix(filepath.Base(event.Name), filepath.Ext(event.Name))
+			switch filepath.Dir(event.Name) {
+			case dir:
+				if filepath.Base(event.Name) == "config.yml" {
+					p.Send(configMsg{})
+				}
+			case themes:
+				if name == themeName() {
+					p.Send(themeMsg{})
+				}
+			}
+
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
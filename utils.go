@@ -6,11 +6,13 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/0xAX/notificator"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/gopxl/beep/speaker"
 )
 
 type lyricLine struct {
@@ -26,6 +28,9 @@ var (
 	artistStyle          lipgloss.Style
 	lyricStyle           lipgloss.Style
 	timeStyle            lipgloss.Style
+	progressStyle        lipgloss.Style
+	progressTrackStyle   lipgloss.Style
+	statusStyle          lipgloss.Style
 	helpMenu             lipgloss.Style
 )
 
@@ -47,28 +52,34 @@ func fallbackAdaptiveColor(value string, def lipgloss.AdaptiveColor) lipgloss.Te
 func initStyles() {
 	screenStyle = lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color(cfg.BorderForeground)).
-		Padding(1, 2).
-		Width(30).
-		MaxWidth(35).
-		Height(14)
+		BorderForeground(lipgloss.Color(activeTheme.BorderForeground)).
+		Padding(1, 2)
 
 	titleStyle = lipgloss.NewStyle().
-		Foreground(fallbackColor(cfg.HeadingForeground, "230")).
-		Background(fallbackColor(cfg.HeadingBackground, "62")).
+		Foreground(fallbackColor(activeTheme.HeadingForeground, "230")).
+		Background(fallbackColor(activeTheme.HeadingBackground, "62")).
 		Padding(0, 1)
 
 	artistStyle = lipgloss.NewStyle().
-		Foreground(fallbackColor(cfg.ArtistForeground, "243")) // muted gray
+		Foreground(fallbackColor(activeTheme.ArtistForeground, "243")) // muted gray
 
 	lyricStyle = lipgloss.NewStyle().
-		Width(26).
 		Align(lipgloss.Center).
-		Foreground(fallbackColor(cfg.LyricsForeground, "252")).
+		Foreground(fallbackColor(activeTheme.LyricsForeground, "252")).
 		Italic(true)
 
 	timeStyle = lipgloss.NewStyle().
-		Foreground(fallbackColor(cfg.TimeForeground, "240"))
+		Foreground(fallbackColor(activeTheme.TimeForeground, "240"))
+
+	progressStyle = lipgloss.NewStyle().
+		Foreground(fallbackColor(activeTheme.HeadingBackground, "62"))
+
+	progressTrackStyle = lipgloss.NewStyle().
+		Foreground(fallbackColor(activeTheme.TimeForeground, "240"))
+
+	statusStyle = lipgloss.NewStyle().
+		Foreground(fallbackColor(activeTheme.TimeForeground, "240")).
+		Padding(0, 1)
 
 	helpMenu = lipgloss.NewStyle().
 		Padding(0, 1)
@@ -80,8 +91,8 @@ func setCustomBubblesStyle() list.Styles {
 	styles := list.DefaultStyles()
 
 	styles.Title = lipgloss.NewStyle().
-		Background(fallbackColor(cfg.HeadingBackground, "62")).
-		Foreground(fallbackColor(cfg.HeadingForeground, "230")).
+		Background(fallbackColor(activeTheme.HeadingBackground, "62")).
+		Foreground(fallbackColor(activeTheme.HeadingForeground, "230")).
 		Padding(0, 1)
 
 	return styles
@@ -92,51 +103,93 @@ func customDelegate() list.ItemDelegate {
 	s := &delegate.Styles
 
 	s.NormalTitle = lipgloss.NewStyle().
-		Foreground(fallbackAdaptiveColor(cfg.NormalTitleForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.NormalTitleForeground,
 					lipgloss.AdaptiveColor{Light: "#1a1a1a", Dark: "#dddddd"})).
 		Padding(0, 0, 0, 2) //nolint:mnd
 
 	s.NormalDesc = s.NormalTitle.
-		Foreground(fallbackAdaptiveColor(cfg.NormalDescForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.NormalDescForeground,
 			lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"}))
 
 	s.SelectedTitle = lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder(), false, false, false, true).
-		BorderForeground(fallbackAdaptiveColor(cfg.SelectedTitleBorderForeground,
+		BorderForeground(fallbackAdaptiveColor(activeTheme.SelectedTitleBorderForeground,
 			lipgloss.AdaptiveColor{Light: "#F793FF", Dark: "#AD58B4"})).
-		Foreground(fallbackAdaptiveColor(cfg.SelectedTitleForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.SelectedTitleForeground,
 			lipgloss.AdaptiveColor{Light: "#EE6FF8", Dark: "#EE6FF8"})).
 		Padding(0, 0, 0, 1)
 
 	s.SelectedDesc = s.SelectedTitle.
-		Foreground(fallbackAdaptiveColor(cfg.SelectedDescForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.SelectedDescForeground,
 			lipgloss.AdaptiveColor{Light: "#F793FF", Dark: "#AD58B4"}))
 
 	s.DimmedTitle = lipgloss.NewStyle().
-		Foreground(fallbackAdaptiveColor(cfg.DimmedTitleForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.DimmedTitleForeground,
 					lipgloss.AdaptiveColor{Light: "#A49FA5", Dark: "#777777"})).
 		Padding(0, 0, 0, 2) //nolint:mnd
 
 	s.DimmedDesc = s.DimmedTitle.
-		Foreground(fallbackAdaptiveColor(cfg.DimmedDescForeground,
+		Foreground(fallbackAdaptiveColor(activeTheme.DimmedDescForeground,
 			lipgloss.AdaptiveColor{Light: "#C2B8C2", Dark: "#4D4D4D"}))
 
-	return delegate
+	return zoneDelegate{delegate}
 }
 
-// place content in the center and add a help menu
+// place content in the center and add the status lines and help menu
 func (m model) center(content string) string {
-	screen := lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, content)
+	screen := lipgloss.Place(m.width, m.bodyHeight(), lipgloss.Center, lipgloss.Center, content)
 
-	if cfg.ShowHelp {
+	if status := m.statusView(); status != "" {
+		screen = lipgloss.JoinVertical(lipgloss.Left, screen, status)
+	}
+	if cfg().ShowHelp {
 		return lipgloss.JoinVertical(lipgloss.Left, screen, helpMenu.Render(m.help.View(keys)))
 	}
 	return screen
 }
 
+// config problems, scan progress, the problems counter and the queue
+func (m model) statusView() string {
+	var lines []string
+	if m.status != "" {
+		lines = append(lines, m.status)
+	}
+	if m.scan != nil && m.page.kind != noPage {
+		lines = append(lines, m.scanView())
+	}
+	if count := lib.problemCount(); count > 0 && m.page.kind != problemsPage {
+		lines = append(lines, problemsView(count))
+	}
+	if len(m.queue) > 0 {
+		lines = append(lines, queueView(len(m.queue)))
+	}
+	if m.scrobbles > 0 {
+		lines = append(lines, scrobbleView(m.scrobbles))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return statusStyle.MaxWidth(m.width).Render(strings.Join(lines, "\n"))
+}
+
+// one line summary of a list of warnings
+func statusText(warnings []string) string {
+	switch len(warnings) {
+	case 0:
+		return ""
+	case 1:
+		return warnings[0]
+	default:
+		return fmt.Sprintf("%s (and %d more)", warnings[0], len(warnings)-1)
+	}
+}
+
 // play next song
 func (m model) nextSong(l list.Model) (list.Model, tea.Cmd) {
 	l.CursorDown()
+	if _, ok := l.SelectedItem().(heading); ok {
+		l.CursorDown()
+	}
 	selected, ok := l.SelectedItem().(music)
 	if !ok {
 		return l, nil
@@ -147,6 +200,9 @@ func (m model) nextSong(l list.Model) (list.Model, tea.Cmd) {
 // play previous song
 func (m model) prevSong(l list.Model) (list.Model, tea.Cmd) {
 	l.CursorUp()
+	if _, ok := l.SelectedItem().(heading); ok {
+		l.CursorUp()
+	}
 	selected, ok := l.SelectedItem().(music)
 	if !ok {
 		return l, nil
@@ -154,38 +210,82 @@ func (m model) prevSong(l list.Model) (list.Model, tea.Cmd) {
 	return l, func() tea.Msg { return playMusic(selected) }
 }
 
-// handle album selection in list
-func (m model) handleAlbumSelection() model {
-	if selected, ok := m.list.SelectedItem().(album); ok {
-		items := make([]list.Item, len(selected.tracks))
-		for i, track := range selected.tracks {
-			items[i] = track
-		}
-		m.list.SetItems(items)
-		m.list.Title = selected.title
-		m.showAlbums = false
-		m.loaded = true
-		m.list.SetFilterState(list.Unfiltered)
+// show musics as the Songs page
+func (m model) showSongs(musics []music) model {
+	items := make([]list.Item, len(musics))
+	for i, m := range musics {
+		items[i] = m
 	}
-	return m
+	return m.setPage(page{kind: songsPage, title: "Songs"}, items)
 }
 
-// handle artist selection in list
-func (m model) handleArtistSelection() model {
-	if selected, ok := m.list.SelectedItem().(artist); ok {
-		items := make([]list.Item, len(selected.tracks))
-		for i, track := range selected.tracks {
-			items[i] = track
-		}
-		m.list.SetItems(items)
-		m.list.Title = selected.name
-		m.showArtists = false
-		m.loaded = true
-		m.list.SetFilterState(list.Unfiltered)
+// open the selected album or artist, or play the selected song
+func (m model) selectItem() (model, tea.Cmd) {
+	switch selected := m.list.SelectedItem().(type) {
+	case album:
+		return m.handleAlbumSelection(selected), nil
+	case artist:
+		return m.handleArtistSelection(selected), nil
+	case genre:
+		return m.openPage(page{kind: genrePage, key: selected.key}, selected.name), nil
+	case decade:
+		return m.openPage(page{kind: decadePage, key: selected.key()}, selected.Title()), nil
+	case allTracks:
+		return m.openPage(selected.page, m.list.Title), nil
+	case smartPlaylist:
+		return m.openPage(page{kind: smartPlaylistPage, key: selected.Query}, selected.Name), nil
+	case autoPlaylist:
+		return m.openPage(page{kind: autoPlaylistPage, key: selected.name}, selected.name), nil
+	case eqPreset:
+		return m.applyPreset(selected), nil
+	case music:
+		return m, func() tea.Msg { return playMusic(selected) }
+	case problem:
+		// try reading a file from the problems page again
+		return m, func() tea.Msg { return retryProblem(selected) }
+	}
+	return m, nil
+}
+
+// run f with the speaker locked, pausing already holds the lock
+func (m model) withSpeaker(f func()) {
+	if !m.paused {
+		speaker.Lock()
+		defer speaker.Unlock()
+	}
+	f()
+}
+
+// jump to a position in the current song
+func (m model) seek(pos time.Duration) model {
+	if m.streamer == nil {
+		return m
 	}
+
+	m.withSpeaker(func() {
+		n := min(max(m.sampleRate.N(pos), 0), m.streamer.Len())
+		m.streamer.Seek(n)
+		m.elapsed = m.sampleRate.D(n).Round(time.Second)
+	})
 	return m
 }
 
+// handle album selection in list
+func (m model) handleAlbumSelection(selected album) model {
+	return m.openPage(page{kind: albumPage, key: selected.key}, selected.title)
+}
+
+// handle artist selection in list
+func (m model) handleArtistSelection(selected artist) model {
+	return m.openPage(page{kind: artistPage, key: selected.name}, selected.name)
+}
+
+// drill into a page, keeping the current list to go back to
+func (m model) openPage(p page, title string) model {
+	p.title = title
+	return m.pushHistory().setPage(p, p.items())
+}
+
 func parseLRC(raw string) ([]lyricLine, error) {
 	var lyrics []lyricLine
 
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checks for values the struct types can't express, keyed by the dotted
+// path of the setting. every theme colour is checked by validColour
+var validators = map[string]func(string) error{
+	"layout":            oneOf("compact", "full", "wide"),
+	"library.max_depth": nonNegative,
+	"replaygain.mode":   oneOf("track", "album", "off"),
+	"eq.bands":          positive,
+	"eq.q":              positive,
+}
+
+func init() {
+	for key := range yamlFields(reflect.TypeOf(theme{})) {
+		validators[key] = validColour
+	}
+}
+
+var hexColour = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func validColour(value string) error {
+	if value == "" || hexColour.MatchString(value) {
+		return nil
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 && n <= 255 {
+		return nil
+	}
+	return fmt.Errorf("invalid colour %q, use a hex colour like \"#5f87af\" or an ANSI colour from 0 to 255", value)
+}
+
+func nonNegative(value string) error {
+	if n, err := strconv.Atoi(value); err == nil && n < 0 {
+		return fmt.Errorf("must be 0 or more, got %d", n)
+	}
+	return nil
+}
+
+func positive(value string) error {
+	if f, err := strconv.ParseFloat(value, 64); err == nil && f <= 0 {
+		return fmt.Errorf("must be more than 0, got %g", f)
+	}
+	return nil
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(value string) error {
+		if value == "" || slices.Contains(values, value) {
+			return nil
+		}
+		return fmt.Errorf("invalid value %q, use one of: %s", value, strings.Join(values, ", "))
+	}
+}
+
+// decode yaml into out, reporting every problem with its line number. unknown
+// keys only produce warnings, bad values are errors
+func decodeYaml(data []byte, file string, out any) ([]string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	// empty file
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: line %d: expected a list of settings like \"key: value\"", file, doc.Line)
+	}
+
+	warnings, errs := checkMapping(doc, reflect.TypeOf(out).Elem(), file, "")
+	if err := doc.Decode(out); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", file, err))
+	}
+	return warnings, errors.Join(errs...)
+}
+
+// check the keys and values of a yaml mapping against the struct type t
+func checkMapping(node *yaml.Node, t reflect.Type, file, prefix string) ([]string, []error) {
+	var warnings []string
+	var errs []error
+
+	fields := yamlFields(t)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := prefix + key.Value
+
+		field, ok := fields[key.Value]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: line %d: unknown key %q%s",
+				file, key.Line, path, suggestKey(key.Value, fields)))
+			continue
+		}
+
+		if field.Kind() == reflect.Struct && value.Kind == yaml.MappingNode {
+			w, e := checkMapping(value, field, file, path+".")
+			warnings = append(warnings, w...)
+			errs = append(errs, e...)
+			continue
+		}
+
+		check, ok := validators[path]
+		if !ok {
+			continue
+		}
+		// lists are checked item by item
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, v := range values {
+			if v.Kind != yaml.ScalarNode {
+				continue
+			}
+			if err := check(v.Value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: line %d: %s: %w", file, v.Line, path, err))
+			}
+		}
+	}
+
+	return warnings, errs
+}
+
+// yaml keys of a struct type and the types they decode into
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+
+		if opts == "inline" {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name != "" && name != "-" {
+			fields[name] = f.Type
+		}
+	}
+	return fields
+}
+
+// a "did you mean" hint for misspelled keys
+func suggestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDistance := "", 4
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
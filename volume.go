@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep/effects"
+)
+
+// how much + and - change the volume, in percent
+const volumeStep = 5
+
+// global volume streamer, always in the chain so the level set before the
+// first song applies to it
+var volume = &effects.Volume{
+	Base:   2,
+	Volume: 0,
+	Silent: false,
+}
+
+// what podden remembers between runs
+type playerState struct {
+	Volume   int       `json:"volume"` // 0 to 100
+	Muted    bool      `json:"muted"`
+	EQPreset string    `json:"eq_preset,omitempty"`
+	EQ       []float64 `json:"eq,omitempty"` // gain of every band in dB
+}
+
+func statePath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "state.json"), nil
+}
+
+// the state saved by the last run, full volume the first time
+func loadState() (playerState, error) {
+	state := playerState{Volume: 100}
+	path, err := statePath()
+	if err != nil {
+		return state, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return playerState{Volume: 100}, err
+	}
+	state.Volume = clampVolume(state.Volume)
+	return state, nil
+}
+
+func saveState(state playerState) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func clampVolume(percent int) int {
+	return min(max(percent, 0), 100)
+}
+
+// point the volume streamer at a level. loudness follows the square of the
+// percentage so every step sounds about as big, call with the speaker locked
+// while a song plays
+func applyVolume(state playerState) {
+	volume.Silent = state.Muted || state.Volume == 0
+	if !volume.Silent {
+		volume.Volume = 2 * math.Log2(float64(state.Volume)/100)
+	}
+}
+
+// set and remember the volume
+func (m model) setVolume(state playerState) model {
+	state.Volume = clampVolume(state.Volume)
+	m.withSpeaker(func() { applyVolume(state) })
+	return m.rememberState(state)
+}
+
+// keep state for the next run
+func (m model) rememberState(state playerState) model {
+	m.state = state
+	if err := saveState(state); err != nil {
+		m.status = "can't save state: " + err.Error()
+	}
+	return m.resize()
+}
+
+// raise or lower the volume by steps, unmuting
+func (m model) changeVolume(steps int) model {
+	state := m.state
+	state.Volume += steps * volumeStep
+	state.Muted = false
+	return m.setVolume(state)
+}
+
+func (m model) toggleMute() model {
+	state := m.state
+	state.Muted = !state.Muted
+	return m.setVolume(state)
+}
+
+// volume bar for the playing view
+func (m model) volumeView() string {
+	if m.state.Muted {
+		return fmt.Sprintf("vol muted (%d%%)", m.state.Volume)
+	}
+	filled := m.state.Volume / 20
+	return fmt.Sprintf("vol %s %d%%", strings.Repeat("▮", filled)+strings.Repeat("▯", 5-filled), m.state.Volume)
+}
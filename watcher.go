@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+)
+
+// sent after the watcher changed the library index
+type libraryChangedMsg struct{}
+
+// downloads and rips write in many small chunks, wait for them to settle
+const watchDebounce = 500 * time.Millisecond
+
+// signalled when the library settings change, so the watcher follows them
+var rewatchLibrary = make(chan struct{}, 1)
+
+// keep the library index in sync with audio files created, changed, moved
+// or deleted in the library directories
+func watchLibrary(p *tea.Program) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	defer watcher.Close()
+
+	// fsnotify isn't recursive, watch every directory of the library
+	addWatches := func() {
+		w := newLibraryWalker(nil, func(dir string) { watcher.Add(dir) })
+		for _, dir := range libraryDirs() {
+			w.walk(filepath.Clean(dir), filepath.Clean(dir))
+		}
+	}
+	addWatches()
+
+	// changes before the first scan finished would be overwritten by it
+	<-lib.ready
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			// a new or moved in directory: watch it and pick up its files,
+			// unless the library settings leave it out
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					w := newLibraryWalker(
+						func(path string) { pending[path] = true },
+						func(dir string) { watcher.Add(dir) },
+					)
+					link, err := os.Lstat(event.Name)
+					linked := err == nil && link.Mode()&fs.ModeSymlink != 0
+					if root, ok := rootOf(event.Name); ok && w.allowed(root, event.Name) && (!linked || w.followSymlinks) {
+						w.walk(root, event.Name)
+					}
+				}
+			}
+
+			pending[event.Name] = true
+			timer.Reset(watchDebounce)
+
+		case <-timer.C:
+			changed := false
+			for path := range pending {
+				changed = syncLibraryPath(path) || changed
+			}
+			clear(pending)
+
+			if changed {
+				p.Send(libraryChangedMsg{})
+			}
+
+		case <-rewatchLibrary:
+			for _, dir := range watcher.WatchList() {
+				watcher.Remove(dir)
+			}
+			addWatches()
+			if pruneLibrary() {
+				p.Send(libraryChangedMsg{})
+			}
+
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// drop tracks the library settings no longer take in, reporting whether
+// there were any. new ones are left to a rescan
+func pruneLibrary() bool {
+	w := newLibraryWalker(nil, nil)
+	changed := false
+	for _, m := range lib.all() {
+		if root, ok := rootOf(m.path); !ok || !w.includes(root, m.path) {
+			changed = lib.remove(m.path) || changed
+		}
+	}
+	return changed
+}
+
+// bring the index entry for path in line with the file system, reporting
+// whether the index changed
+func syncLibraryPath(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		// deleted or moved away, drops everything below a directory too
+		return lib.remove(path)
+	}
+	if info.IsDir() {
+		return false
+	}
+
+	root, ok := rootOf(path)
+	if !ok || !newLibraryWalker(nil, nil).includes(root, path) {
+		return lib.remove(path)
+	}
+
+	m, err := readMusic(path)
+	if err != nil {
+		lib.remove(path)
+		lib.addProblems([]problem{newProblem(path, err)})
+		return true
+	}
+	lib.set(m)
+	return true
+}